@@ -28,7 +28,9 @@ package ast
 
 import (
     "calculator/token"
+    "calculator/units"
     "fmt"
+    "strings"
 )
 
 type ASTNode interface {
@@ -40,6 +42,27 @@ type ASTVisitor interface {
     VisitBinaryOperation(node *BinaryOperation) (interface{}, error)
     VisitUnaryOperation(node *UnaryOperation) (interface{}, error)
     VisitNumberLiteral(node *NumberLiteral) (interface{}, error)
+    VisitFloatLiteral(node *FloatLiteral) (interface{}, error)
+    VisitImaginaryLiteral(node *ImaginaryLiteral) (interface{}, error)
+    VisitBooleanLiteral(node *BooleanLiteral) (interface{}, error)
+    VisitVariable(node *Variable) (interface{}, error)
+    VisitHistoryReference(node *HistoryReference) (interface{}, error)
+    VisitLogicalOperation(node *LogicalOperation) (interface{}, error)
+    VisitConditional(node *Conditional) (interface{}, error)
+    VisitBlock(node *Block) (interface{}, error)
+    VisitAssignment(node *Assignment) (interface{}, error)
+    VisitCall(node *Call) (interface{}, error)
+    VisitEquation(node *Equation) (interface{}, error)
+    VisitMatrixLiteral(node *MatrixLiteral) (interface{}, error)
+    VisitListLiteral(node *ListLiteral) (interface{}, error)
+    VisitRange(node *Range) (interface{}, error)
+    VisitIndex(node *Index) (interface{}, error)
+    VisitMeasure(node *Measure) (interface{}, error)
+    VisitConversion(node *Conversion) (interface{}, error)
+    VisitFunctionDefinition(node *FunctionDefinition) (interface{}, error)
+    VisitWhile(node *While) (interface{}, error)
+    VisitFor(node *For) (interface{}, error)
+    VisitBreak(node *Break) (interface{}, error)
     VisitErrorNode(node *ErrorNode) (interface {}, error)
 }
 
@@ -67,21 +90,30 @@ func (bo *BinaryOperation) String() string {
     return fmt.Sprintf("(%v %s %v)", bo.LeftChild, bo.Operator.TokenType, bo.RightChild)
 }
 
-// UnaryOperation nodes: has one child node which is the expression immediately following 
+// UnaryOperation nodes: has one child node which is the expression immediately following the operator, or
+// for a postfix operator (5!, 10%) the expression immediately before it
 type UnaryOperation struct {
     Operator *token.Token
     Expr ASTNode 
+    Postfix bool
 }
 
 func NewUnaryOperation(operator *token.Token, expr ASTNode) ASTNode {
     return &UnaryOperation{Operator: operator, Expr: expr}
 }
 
+func NewPostfixOperation(operator *token.Token, expr ASTNode) ASTNode {
+    return &UnaryOperation{Operator: operator, Expr: expr, Postfix: true}
+}
+
 func (uo *UnaryOperation) Accept(v ASTVisitor) (interface{}, error) {
     return v.VisitUnaryOperation(uo)
 }
 
 func (uo *UnaryOperation) String() string {
+    if uo.Postfix {
+        return fmt.Sprintf("(%v)(%s)", uo.Expr, uo.Operator.TokenType)
+    }
     return fmt.Sprintf("(%s)(%v)", uo.Operator.TokenType, uo.Expr)//, nl.Value)
 }
 
@@ -104,10 +136,466 @@ func (nl *NumberLiteral) Accept(v ASTVisitor) (interface{}, error) {
     return v.VisitNumberLiteral(nl)
 }
 
+// the number as it was written in the input 
 func (nl *NumberLiteral) String() string {
+    if nl.Token.Literal != "" {
+        return nl.Token.Literal
+    }
     return fmt.Sprintf("%d", nl.Value)
 }
 
+// FloatLiteral nodes: leaf nodes holding decimal numbers (12.5) 
+type FloatLiteral struct {
+    Token *token.Token
+    Value float64
+}
+
+func NewFloatLiteral(token *token.Token) (ASTNode, error) {
+    value, ok := token.Value.(float64) // type assertion 
+    if !ok {
+        return nil, fmt.Errorf("ast.NewFloatLiteral(): token.TokenValue is not a float64")
+    }
+    return &FloatLiteral{Token: token, Value: value}, nil
+}
+
+func (fl *FloatLiteral) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitFloatLiteral(fl)
+}
+
+// the number as it was written in the input 
+func (fl *FloatLiteral) String() string {
+    return fl.Token.Literal
+}
+
+// ImaginaryLiteral nodes: leaf nodes holding imaginary numbers (2i), the value is the imaginary part
+type ImaginaryLiteral struct {
+    Token *token.Token
+    Value float64
+}
+
+func NewImaginaryLiteral(token *token.Token) (ASTNode, error) {
+    value, ok := token.Value.(float64) // type assertion 
+    if !ok {
+        return nil, fmt.Errorf("ast.NewImaginaryLiteral(): token.TokenValue is not a float64")
+    }
+    return &ImaginaryLiteral{Token: token, Value: value}, nil
+}
+
+func (il *ImaginaryLiteral) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitImaginaryLiteral(il)
+}
+
+// the number as it was written in the input 
+func (il *ImaginaryLiteral) String() string {
+    return il.Token.Literal
+}
+
+// BooleanLiteral nodes: leaf nodes holding true or false
+type BooleanLiteral struct {
+    Token *token.Token
+    Value bool
+}
+
+func NewBooleanLiteral(token *token.Token) (ASTNode, error) {
+    value, ok := token.Value.(bool) // type assertion 
+    if !ok {
+        return nil, fmt.Errorf("ast.NewBooleanLiteral(): token.TokenValue is not a bool")
+    }
+    return &BooleanLiteral{Token: token, Value: value}, nil
+}
+
+func (bl *BooleanLiteral) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitBooleanLiteral(bl)
+}
+
+func (bl *BooleanLiteral) String() string {
+    return fmt.Sprintf("%t", bl.Value)
+}
+
+// Variable nodes: leaf nodes holding the name of a variable, its value is looked up by the visitor
+type Variable struct {
+    Token *token.Token
+    Name string
+}
+
+func NewVariable(token *token.Token) (ASTNode, error) {
+    name, ok := token.Value.(string) // type assertion 
+    if !ok {
+        return nil, fmt.Errorf("ast.NewVariable(): token.TokenValue is not a string")
+    }
+    return &Variable{Token: token, Name: name}, nil
+}
+
+func (va *Variable) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitVariable(va)
+}
+
+func (va *Variable) String() string {
+    return va.Name
+}
+
+// HistoryReference nodes: leaf nodes referring to an earlier result by its number ($3), the result is
+// looked up by the visitor 
+type HistoryReference struct {
+    Token *token.Token
+    Index int
+}
+
+func NewHistoryReference(token *token.Token) (ASTNode, error) {
+    index, ok := token.Value.(int) // type assertion 
+    if !ok {
+        return nil, fmt.Errorf("ast.NewHistoryReference(): token.TokenValue is not an int")
+    }
+    return &HistoryReference{Token: token, Index: index}, nil
+}
+
+func (hr *HistoryReference) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitHistoryReference(hr)
+}
+
+func (hr *HistoryReference) String() string {
+    return fmt.Sprintf("$%d", hr.Index)
+}
+
+// LogicalOperation nodes: AND / OR, kept apart from BinaryOperation because the right child is only
+// evaluated when the left child does not already decide the result (short-circuit evaluation)
+type LogicalOperation struct {
+    LeftChild ASTNode
+    Operator *token.Token
+    RightChild ASTNode
+}
+
+func NewLogicalOperation(leftChild, rightChild ASTNode, operator *token.Token) ASTNode {
+    return &LogicalOperation{LeftChild: leftChild, Operator: operator, RightChild: rightChild}
+}
+
+func (lo *LogicalOperation) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitLogicalOperation(lo)
+}
+
+func (lo *LogicalOperation) String() string {
+    return fmt.Sprintf("(%v %s %v)", lo.LeftChild, lo.Operator.TokenType, lo.RightChild)
+}
+
+// Conditional nodes: cond ? a : b and if cond then a else b. Only the branch selected by the condition is
+// evaluated. Token is the '?' or 'if' that started the conditional. 
+type Conditional struct {
+    Token *token.Token
+    Condition ASTNode
+    Consequent ASTNode
+    Alternative ASTNode
+}
+
+func NewConditional(token *token.Token, condition, consequent, alternative ASTNode) ASTNode {
+    return &Conditional{Token: token, Condition: condition, Consequent: consequent, Alternative: alternative}
+}
+
+func (c *Conditional) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitConditional(c)
+}
+
+func (c *Conditional) String() string {
+    return fmt.Sprintf("(%v ? %v : %v)", c.Condition, c.Consequent, c.Alternative)
+}
+
+// Block nodes: a list of statements evaluated in order, the value of the block is the value of the last
+// statement. The root of every AST is a Block holding the statements of the whole input; blocks in braces
+// { ... } are nested inside it. Token is the first token of the block. 
+type Block struct {
+    Token *token.Token
+    Statements []ASTNode
+}
+
+func NewBlock(token *token.Token, statements []ASTNode) ASTNode {
+    return &Block{Token: token, Statements: statements}
+}
+
+func (b *Block) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitBlock(b)
+}
+
+func (b *Block) String() string {
+    statements := make([]string, len(b.Statements))
+    for i, statement := range b.Statements {
+        statements[i] = statement.String()
+    }
+    return fmt.Sprintf("{%s}", strings.Join(statements, "; "))
+}
+
+// Assignment nodes: name = value. The value of the assignment is the assigned value. 
+type Assignment struct {
+    Token *token.Token
+    Variable *Variable
+    Value ASTNode
+}
+
+func NewAssignment(token *token.Token, variable *Variable, value ASTNode) ASTNode {
+    return &Assignment{Token: token, Variable: variable, Value: value}
+}
+
+func (a *Assignment) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitAssignment(a)
+}
+
+func (a *Assignment) String() string {
+    return fmt.Sprintf("(%v = %v)", a.Variable, a.Value)
+}
+
+// Call nodes: name(arguments), a call of a function 
+type Call struct {
+    Token *token.Token
+    Name string
+    Arguments []ASTNode
+}
+
+func NewCall(token *token.Token, name string, arguments []ASTNode) ASTNode {
+    return &Call{Token: token, Name: name, Arguments: arguments}
+}
+
+func (c *Call) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitCall(c)
+}
+
+func (c *Call) String() string {
+    arguments := make([]string, len(c.Arguments))
+    for i, argument := range c.Arguments {
+        arguments[i] = argument.String()
+    }
+    return fmt.Sprintf("%s(%s)", c.Name, strings.Join(arguments, ", "))
+}
+
+// Equation nodes: left = right, an argument to solve(). An equation has no value of its own. 
+type Equation struct {
+    Token *token.Token
+    Left ASTNode
+    Right ASTNode
+}
+
+func NewEquation(token *token.Token, left, right ASTNode) ASTNode {
+    return &Equation{Token: token, Left: left, Right: right}
+}
+
+func (e *Equation) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitEquation(e)
+}
+
+func (e *Equation) String() string {
+    return fmt.Sprintf("(%v = %v)", e.Left, e.Right)
+}
+
+// MatrixLiteral nodes: [1, 2; 3, 4], the elements row by row
+type MatrixLiteral struct {
+    Token *token.Token
+    Rows [][]ASTNode
+}
+
+func NewMatrixLiteral(token *token.Token, rows [][]ASTNode) ASTNode {
+    return &MatrixLiteral{Token: token, Rows: rows}
+}
+
+func (ml *MatrixLiteral) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitMatrixLiteral(ml)
+}
+
+func (ml *MatrixLiteral) String() string {
+    rows := make([]string, len(ml.Rows))
+    for i, row := range ml.Rows {
+        elements := make([]string, len(row))
+        for j, element := range row {
+            elements[j] = fmt.Sprintf("%v", element)
+        }
+        rows[i] = strings.Join(elements, ", ")
+    }
+    return "[" + strings.Join(rows, "; ") + "]"
+}
+
+// ListLiteral nodes: [1, 2, 3]
+type ListLiteral struct {
+    Token *token.Token
+    Elements []ASTNode
+}
+
+func NewListLiteral(token *token.Token, elements []ASTNode) ASTNode {
+    return &ListLiteral{Token: token, Elements: elements}
+}
+
+func (ll *ListLiteral) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitListLiteral(ll)
+}
+
+func (ll *ListLiteral) String() string {
+    elements := make([]string, len(ll.Elements))
+    for i, element := range ll.Elements {
+        elements[i] = fmt.Sprintf("%v", element)
+    }
+    return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// Range nodes: start..end, the list of the integers from start to end
+type Range struct {
+    Token *token.Token
+    Start ASTNode
+    End ASTNode
+}
+
+func NewRange(token *token.Token, start, end ASTNode) ASTNode {
+    return &Range{Token: token, Start: start, End: end}
+}
+
+func (r *Range) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitRange(r)
+}
+
+func (r *Range) String() string {
+    return fmt.Sprintf("(%v..%v)", r.Start, r.End)
+}
+
+// Measure nodes: a number literal with a unit, 5 km or 9.8 m/s^2. Attached is the name of the unit if it was
+// written right after the number in implicit multiplication mode (the m of 2m), where it could be a factor too.
+type Measure struct {
+    Token    *token.Token
+    Value    ASTNode
+    Unit     units.Unit
+    Attached string
+}
+
+func NewMeasure(token *token.Token, value ASTNode, unit units.Unit, attached string) ASTNode {
+    return &Measure{Token: token, Value: value, Unit: unit, Attached: attached}
+}
+
+func (m *Measure) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitMeasure(m)
+}
+
+func (m *Measure) String() string {
+    return fmt.Sprintf("(%v %v)", m.Value, m.Unit)
+}
+
+// Conversion nodes: expr to unit, a quantity converted into another unit of the same dimension (3 ft to m)
+type Conversion struct {
+    Token *token.Token
+    Expr  ASTNode
+    Unit  units.Unit
+}
+
+func NewConversion(token *token.Token, expr ASTNode, unit units.Unit) ASTNode {
+    return &Conversion{Token: token, Expr: expr, Unit: unit}
+}
+
+func (c *Conversion) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitConversion(c)
+}
+
+func (c *Conversion) String() string {
+    return fmt.Sprintf("(%v to %v)", c.Expr, c.Unit)
+}
+
+// Index nodes: expr[index] or expr[row, column], an element of a list or matrix
+type Index struct {
+    Token *token.Token
+    Expr ASTNode
+    Indices []ASTNode
+}
+
+func NewIndex(token *token.Token, expr ASTNode, indices []ASTNode) ASTNode {
+    return &Index{Token: token, Expr: expr, Indices: indices}
+}
+
+func (i *Index) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitIndex(i)
+}
+
+func (i *Index) String() string {
+    indices := make([]string, len(i.Indices))
+    for j, index := range i.Indices {
+        indices[j] = fmt.Sprintf("%v", index)
+    }
+    return fmt.Sprintf("%v[%s]", i.Expr, strings.Join(indices, ", "))
+}
+
+// FunctionDefinition nodes: name(parameters) = body. A definition has no value, it makes the function
+// available to the statements after it. 
+type FunctionDefinition struct {
+    Token *token.Token
+    Name string
+    Parameters []*Variable
+    Body ASTNode
+}
+
+func NewFunctionDefinition(token *token.Token, name string, parameters []*Variable, body ASTNode) ASTNode {
+    return &FunctionDefinition{Token: token, Name: name, Parameters: parameters, Body: body}
+}
+
+func (fd *FunctionDefinition) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitFunctionDefinition(fd)
+}
+
+func (fd *FunctionDefinition) String() string {
+    parameters := make([]string, len(fd.Parameters))
+    for i, parameter := range fd.Parameters {
+        parameters[i] = parameter.Name
+    }
+    return fmt.Sprintf("(%s(%s) = %v)", fd.Name, strings.Join(parameters, ", "), fd.Body)
+}
+
+// While nodes: while condition { body }. The body is evaluated for as long as the condition is true. 
+type While struct {
+    Token *token.Token
+    Condition ASTNode
+    Body *Block
+}
+
+func NewWhile(token *token.Token, condition ASTNode, body *Block) ASTNode {
+    return &While{Token: token, Condition: condition, Body: body}
+}
+
+func (w *While) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitWhile(w)
+}
+
+func (w *While) String() string {
+    return fmt.Sprintf("while %v %v", w.Condition, w.Body)
+}
+
+// For nodes: for variable in start..end { body }. The body is evaluated once for each integer from start
+// to end inclusive, with the variable set to that integer. 
+type For struct {
+    Token *token.Token
+    Variable *Variable
+    Start ASTNode
+    End ASTNode
+    Body *Block
+}
+
+func NewFor(token *token.Token, variable *Variable, start, end ASTNode, body *Block) ASTNode {
+    return &For{Token: token, Variable: variable, Start: start, End: end, Body: body}
+}
+
+func (f *For) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitFor(f)
+}
+
+func (f *For) String() string {
+    return fmt.Sprintf("for %v in %v..%v %v", f.Variable, f.Start, f.End, f.Body)
+}
+
+// Break nodes: leave the innermost loop
+type Break struct {
+    Token *token.Token
+}
+
+func NewBreak(token *token.Token) ASTNode {
+    return &Break{Token: token}
+}
+
+func (b *Break) Accept(v ASTVisitor) (interface{}, error) {
+    return v.VisitBreak(b)
+}
+
+func (b *Break) String() string {
+    return "break"
+}
+
 
 // ErrorNodes are in the case that an error arises, the calling method may still return a node and
 // the error message can be saved within the node in case later on, the specific error and location
@@ -128,3 +616,75 @@ func (en *ErrorNode) String() string {
     return fmt.Sprintf("Node: %v",en.ErrorType)
 }
 
+
+
+// Inspect traverses the AST rooted at node in depth-first order, calling f on each node. If f returns
+// false, the children of that node are not visited. 
+func Inspect(node ASTNode, f func(ASTNode) bool) {
+    if node == nil || !f(node) {
+        return
+    }
+    switch n := node.(type) {
+    case *BinaryOperation:
+        Inspect(n.LeftChild, f)
+        Inspect(n.RightChild, f)
+    case *LogicalOperation:
+        Inspect(n.LeftChild, f)
+        Inspect(n.RightChild, f)
+    case *UnaryOperation:
+        Inspect(n.Expr, f)
+    case *Block:
+        for _, statement := range n.Statements {
+            Inspect(statement, f)
+        }
+    case *Assignment:
+        Inspect(n.Variable, f)
+        Inspect(n.Value, f)
+    case *Call:
+        for _, argument := range n.Arguments {
+            Inspect(argument, f)
+        }
+    case *Equation:
+        Inspect(n.Left, f)
+        Inspect(n.Right, f)
+    case *MatrixLiteral:
+        for _, row := range n.Rows {
+            for _, element := range row {
+                Inspect(element, f)
+            }
+        }
+    case *ListLiteral:
+        for _, element := range n.Elements {
+            Inspect(element, f)
+        }
+    case *Range:
+        Inspect(n.Start, f)
+        Inspect(n.End, f)
+    case *Measure:
+        Inspect(n.Value, f)
+    case *Conversion:
+        Inspect(n.Expr, f)
+    case *Index:
+        Inspect(n.Expr, f)
+        for _, index := range n.Indices {
+            Inspect(index, f)
+        }
+    case *FunctionDefinition:
+        for _, parameter := range n.Parameters {
+            Inspect(parameter, f)
+        }
+        Inspect(n.Body, f)
+    case *While:
+        Inspect(n.Condition, f)
+        Inspect(n.Body, f)
+    case *For:
+        Inspect(n.Variable, f)
+        Inspect(n.Start, f)
+        Inspect(n.End, f)
+        Inspect(n.Body, f)
+    case *Conditional:
+        Inspect(n.Condition, f)
+        Inspect(n.Consequent, f)
+        Inspect(n.Alternative, f)
+    }
+}
@@ -0,0 +1,483 @@
+package calculus
+
+/*
+
+Symbolic differentiation of expressions. Derivative takes the AST of an expression and returns a new AST
+for its derivative with respect to one variable, using the sum, product, quotient, power and chain rules,
+then simplified (x * 1 is x, 2 * (3 * x) is 6 * x, ...). The trees can be written as infix text with
+interpreter.Source or evaluated like any other.
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/interpreter"
+    "calculator/token"
+    "fmt"
+    "math"
+    "strconv"
+)
+
+const (
+    PLUS    = "PLUS"
+    MINUS   = "MINUS"
+    MUL     = "MUL"
+    DIV     = "DIV"
+    POWER   = "POWER"
+    INTEGER = "INTEGER"
+    FLOAT   = "FLOAT"
+    IDENT   = "IDENT"
+)
+
+// Derivative returns the simplified derivative of expr with respect to the variable. Expressions may use
+// + - * / ^, numbers, variables, constants and the built-in functions; the derivative of anything else that
+// depends on the variable is an error.
+func Derivative(expr ast.ASTNode, variable string) (ast.ASTNode, error) {
+    derivative, err := derive(expr, variable)
+    if err != nil {
+        return nil, err
+    }
+    return Simplify(derivative), nil
+}
+
+// depends reports whether the expression contains the variable
+func depends(expr ast.ASTNode, variable string) bool {
+    found := false
+    ast.Inspect(expr, func(node ast.ASTNode) bool {
+        if v, ok := node.(*ast.Variable); ok && v.Name == variable {
+            found = true
+        }
+        return !found
+    })
+    return found
+}
+
+// derive applies the rules of differentiation, without simplifying
+func derive(expr ast.ASTNode, variable string) (ast.ASTNode, error) {
+    if !depends(expr, variable) {
+        return number(0), nil
+    }
+    switch n := expr.(type) {
+    case *ast.Variable:
+        return number(1), nil
+    case *ast.UnaryOperation:
+        if n.Postfix || n.Operator.TokenType != PLUS && n.Operator.TokenType != MINUS {
+            break
+        }
+        du, err := derive(n.Expr, variable)
+        if err != nil {
+            return nil, err
+        }
+        if n.Operator.TokenType == MINUS {
+            return negate(du), nil
+        }
+        return du, nil
+    case *ast.BinaryOperation:
+        u, v := n.LeftChild, n.RightChild
+        du, err := derive(u, variable)
+        if err != nil {
+            return nil, err
+        }
+        dv, err := derive(v, variable)
+        if err != nil {
+            return nil, err
+        }
+        switch n.Operator.TokenType {
+        case PLUS, MINUS:
+            return binary(n.Operator.TokenType, du, dv), nil
+        case MUL:
+            // (u v)' = u' v + u v'
+            return binary(PLUS, binary(MUL, du, v), binary(MUL, u, dv)), nil
+        case DIV:
+            // (u / v)' = (u' v - u v') / v^2
+            return binary(DIV, binary(MINUS, binary(MUL, du, v), binary(MUL, u, dv)), binary(POWER, v, number(2))), nil
+        case POWER:
+            if !depends(v, variable) {
+                // (u^c)' = c u^(c - 1) u'
+                return binary(MUL, binary(MUL, v, binary(POWER, u, binary(MINUS, v, number(1)))), du), nil
+            }
+            if !depends(u, variable) {
+                // (c^v)' = c^v ln(c) v'
+                return binary(MUL, binary(MUL, n, call("ln", u)), dv), nil
+            }
+            // (u^v)' = u^v (v' ln(u) + v u' / u)
+            return binary(MUL, n, binary(PLUS, binary(MUL, dv, call("ln", u)), binary(DIV, binary(MUL, v, du), u))), nil
+        }
+    case *ast.Call:
+        if len(n.Arguments) != 1 || !interpreter.IsBuiltin(n.Name) {
+            return nil, fmt.Errorf("calculus.Derivative(): cannot differentiate %s, only built-in functions", n.Name)
+        }
+        u := n.Arguments[0]
+        du, err := derive(u, variable)
+        if err != nil {
+            return nil, err
+        }
+        // chain rule: f(u)' = f'(u) u'
+        var outer ast.ASTNode
+        switch n.Name {
+        case "sin":
+            outer = call("cos", u)
+        case "cos":
+            outer = negate(call("sin", u))
+        case "tan":
+            outer = binary(DIV, number(1), binary(POWER, call("cos", u), number(2)))
+        case "asin":
+            outer = binary(DIV, number(1), call("sqrt", binary(MINUS, number(1), binary(POWER, u, number(2)))))
+        case "acos":
+            outer = negate(binary(DIV, number(1), call("sqrt", binary(MINUS, number(1), binary(POWER, u, number(2))))))
+        case "atan":
+            outer = binary(DIV, number(1), binary(PLUS, number(1), binary(POWER, u, number(2))))
+        case "exp":
+            outer = n
+        case "ln":
+            outer = binary(DIV, number(1), u)
+        case "log":
+            outer = binary(DIV, number(1), binary(MUL, u, call("ln", number(10))))
+        case "sqrt":
+            outer = binary(DIV, number(1), binary(MUL, number(2), n))
+        case "abs":
+            outer = binary(DIV, u, n)
+        default:
+            // the built-in functions of matrices, lists and the parts of complex numbers
+            return nil, fmt.Errorf("calculus.Derivative(): cannot differentiate %s", n.Name)
+        }
+        return binary(MUL, outer, du), nil
+    }
+    return nil, fmt.Errorf("calculus.Derivative(): cannot differentiate %s", interpreter.Source(expr))
+}
+
+// Simplify returns an equivalent expression with the simplifications that differentiation calls for:
+// constant operations folded, 0 and 1 removed from sums and products, like terms collected (x - x is 0),
+// numbers moved to the front of products, double negations removed and ln(e) written as 1 while e is the
+// constant (so the derivative of e^x is e^x). It is applied until nothing changes.
+func Simplify(expr ast.ASTNode) ast.ASTNode {
+    for i := 0; i < maxPasses; i++ {
+        simplified := simplify(expr)
+        if interpreter.Source(simplified) == interpreter.Source(expr) {
+            break
+        }
+        expr = simplified
+    }
+    return expr
+}
+
+// every pass makes the expression smaller or moves numbers to the front, this is only a safety net
+const maxPasses = 100
+
+func simplify(expr ast.ASTNode) ast.ASTNode {
+    switch n := expr.(type) {
+    case *ast.UnaryOperation:
+        operand := simplify(n.Expr)
+        if n.Postfix || n.Operator.TokenType != MINUS && n.Operator.TokenType != PLUS {
+            return &ast.UnaryOperation{Operator: n.Operator, Expr: operand, Postfix: n.Postfix}
+        }
+        if n.Operator.TokenType == PLUS {
+            return operand
+        }
+        return negate(operand)
+    case *ast.Call:
+        arguments := make([]ast.ASTNode, len(n.Arguments))
+        for i, argument := range n.Arguments {
+            arguments[i] = simplify(argument)
+        }
+        if n.Name == "ln" && len(arguments) == 1 && euler(arguments[0]) {
+            return number(1)
+        }
+        return ast.NewCall(n.Token, n.Name, arguments)
+    case *ast.BinaryOperation:
+        return simplifyBinary(n.Operator.TokenType, simplify(n.LeftChild), simplify(n.RightChild), n)
+    }
+    return expr
+}
+
+// simplifyBinary simplifies an operation on two simplified operands. original is returned (with the new
+// operands) for operators it doesn't know.
+func simplifyBinary(operator string, u, v ast.ASTNode, original *ast.BinaryOperation) ast.ASTNode {
+    a, uNumber := value(u)
+    b, vNumber := value(v)
+    if uNumber && vNumber {
+        if folded, ok := fold(operator, a, b); ok {
+            return number(folded)
+        }
+    }
+    // a - -b is a + b, a + -b is a - b
+    if inner, ok := negated(v); ok && (operator == PLUS || operator == MINUS) {
+        if operator == PLUS {
+            return binary(MINUS, u, inner)
+        }
+        return binary(PLUS, u, inner)
+    }
+    // x + x is 2 * x, 1 + x - x is 1
+    if operator == PLUS || operator == MINUS {
+        if collected, ok := collect(binary(operator, u, v)); ok {
+            return collected
+        }
+    }
+    same := interpreter.Source(u) == interpreter.Source(v)
+    switch operator {
+    case PLUS:
+        switch {
+        case uNumber && a == 0:
+            return v
+        case vNumber && b == 0:
+            return u
+        }
+    case MINUS:
+        switch {
+        case vNumber && b == 0:
+            return u
+        case uNumber && a == 0:
+            return negate(v)
+        }
+    case MUL:
+        switch {
+        case uNumber && a == 0 || vNumber && b == 0:
+            return number(0)
+        case uNumber && a == 1:
+            return v
+        case vNumber && b == 1:
+            return u
+        case vNumber && !uNumber:
+            return simplifyBinary(MUL, v, u, nil) // numbers first: x * 3 is 3 * x
+        case same:
+            return binary(POWER, u, number(2))
+        }
+        // u * (1 / w) and (1 / w) * u are u / w
+        if quotient, ok := v.(*ast.BinaryOperation); ok && quotient.Operator.TokenType == DIV {
+            if one, ok := value(quotient.LeftChild); ok && one == 1 {
+                return binary(DIV, u, quotient.RightChild)
+            }
+        }
+        if quotient, ok := u.(*ast.BinaryOperation); ok && quotient.Operator.TokenType == DIV {
+            if one, ok := value(quotient.LeftChild); ok && one == 1 {
+                return binary(DIV, v, quotient.RightChild)
+            }
+        }
+        // -u * v and u * -v are -(u * v)
+        if inner, ok := negated(u); ok {
+            return negate(simplifyBinary(MUL, inner, v, nil))
+        }
+        if inner, ok := negated(v); ok {
+            return negate(simplifyBinary(MUL, u, inner, nil))
+        }
+        // a * (b * w) is (a * b) * w
+        if product, ok := v.(*ast.BinaryOperation); ok && uNumber && product.Operator.TokenType == MUL {
+            if c, ok := value(product.LeftChild); ok {
+                if folded, ok := fold(MUL, a, c); ok {
+                    return simplifyBinary(MUL, number(folded), product.RightChild, nil)
+                }
+            }
+        }
+        // u * (b * w) is b * (u * w), so the numbers of a product end up in front
+        if product, ok := v.(*ast.BinaryOperation); ok && !uNumber && product.Operator.TokenType == MUL {
+            if _, ok := value(product.LeftChild); ok {
+                return binary(MUL, product.LeftChild, simplifyBinary(MUL, u, product.RightChild, nil))
+            }
+        }
+        // (a * w) * v is a * (w * v)
+        if product, ok := u.(*ast.BinaryOperation); ok && product.Operator.TokenType == MUL {
+            if _, ok := value(product.LeftChild); ok {
+                return simplifyBinary(MUL, product.LeftChild, binary(MUL, product.RightChild, v), nil)
+            }
+        }
+    case DIV:
+        switch {
+        case uNumber && a == 0 && !(vNumber && b == 0):
+            return number(0)
+        case vNumber && b == 1:
+            return u
+        case same:
+            return number(1) // where u is defined
+        }
+    case POWER:
+        switch {
+        case vNumber && b == 0:
+            return number(1)
+        case vNumber && b == 1:
+            return u
+        }
+    }
+    if original != nil {
+        return ast.NewBinaryOperation(u, v, original.Operator)
+    }
+    return binary(operator, u, v)
+}
+
+// term is coefficient * rest in a sum, rest is nil for a number
+type term struct {
+    coefficient float64
+    rest        ast.ASTNode
+}
+
+// terms appends the terms of a sum or difference to list, each multiplied by sign: 1 + x - 2 * y is 1, x
+// and -2 * y
+func terms(expr ast.ASTNode, sign float64, list []term) []term {
+    if n, ok := expr.(*ast.BinaryOperation); ok && (n.Operator.TokenType == PLUS || n.Operator.TokenType == MINUS) {
+        list = terms(n.LeftChild, sign, list)
+        if n.Operator.TokenType == MINUS {
+            sign = -sign
+        }
+        return terms(n.RightChild, sign, list)
+    }
+    if c, ok := value(expr); ok {
+        return append(list, term{sign * c, nil})
+    }
+    if inner, ok := negated(expr); ok {
+        return terms(inner, -sign, list)
+    }
+    if product, ok := expr.(*ast.BinaryOperation); ok && product.Operator.TokenType == MUL {
+        if c, ok := value(product.LeftChild); ok {
+            return append(list, term{sign * c, product.RightChild})
+        }
+    }
+    return append(list, term{sign, expr})
+}
+
+// collect adds up the like terms of a sum, in the order they first appear: 2 * x ^ 2 + x ^ 2 is 3 * x ^ 2
+// and 1 + x - x is 1. ok is false if no two terms are alike, and the sum is left as it is.
+func collect(sum ast.ASTNode) (ast.ASTNode, bool) {
+    list := terms(sum, 1, nil)
+    collected := make([]term, 0, len(list))
+    index := make(map[string]int) // the terms by the source of their rest, "" for numbers
+    for _, t := range list {
+        key := ""
+        if t.rest != nil {
+            key = interpreter.Source(t.rest)
+        }
+        if i, ok := index[key]; ok {
+            collected[i].coefficient += t.coefficient
+            continue
+        }
+        index[key] = len(collected)
+        collected = append(collected, t)
+    }
+    if len(collected) == len(list) {
+        return nil, false
+    }
+    var result ast.ASTNode
+    for _, t := range collected {
+        if t.coefficient == 0 {
+            continue
+        }
+        magnitude := number(math.Abs(t.coefficient))
+        if t.rest != nil {
+            magnitude = simplifyBinary(MUL, magnitude, t.rest, nil)
+        }
+        switch {
+        case result == nil && t.coefficient < 0:
+            result = negate(magnitude)
+        case result == nil:
+            result = magnitude
+        case t.coefficient < 0:
+            result = binary(MINUS, result, magnitude)
+        default:
+            result = binary(PLUS, result, magnitude)
+        }
+    }
+    if result == nil {
+        return number(0), true
+    }
+    return result, true
+}
+
+// euler reports whether expr is the constant e, which may have been replaced by interpreter.RegisterConstant
+func euler(expr ast.ASTNode) bool {
+    v, ok := expr.(*ast.Variable)
+    if !ok || v.Name != "e" {
+        return false
+    }
+    constant, ok := interpreter.LookupConstant("e")
+    return ok && constant.Value == math.E && constant.Unit == ""
+}
+
+// fold computes an operation on two numbers. A quotient is folded into a float, since the interpreter would
+// divide integer literals with an integer division (1 / 2 is 0). Powers are only folded to whole numbers.
+func fold(operator string, a, b float64) (float64, bool) {
+    var result float64
+    switch operator {
+    case PLUS:
+        result = a + b
+    case MINUS:
+        result = a - b
+    case MUL:
+        result = a * b
+    case DIV:
+        if b == 0 {
+            return 0, false
+        }
+        result = a / b
+    case POWER:
+        if b < 0 || b != math.Trunc(b) || a == 0 && b == 0 {
+            return 0, false
+        }
+        result = math.Pow(a, b)
+    default:
+        return 0, false
+    }
+    if math.IsInf(result, 0) || math.IsNaN(result) || math.Abs(result) > interpreter.MaxInt {
+        return 0, false
+    }
+    return result, true
+}
+
+// value returns the value of a number literal, or of a negated one
+func value(expr ast.ASTNode) (float64, bool) {
+    switch n := expr.(type) {
+    case *ast.NumberLiteral:
+        return float64(n.Value), true
+    case *ast.FloatLiteral:
+        return n.Value, true
+    }
+    if inner, ok := negated(expr); ok {
+        if v, ok := value(inner); ok {
+            return -v, true
+        }
+    }
+    return 0, false
+}
+
+// negated returns the operand of a unary minus
+func negated(expr ast.ASTNode) (ast.ASTNode, bool) {
+    if n, ok := expr.(*ast.UnaryOperation); ok && !n.Postfix && n.Operator.TokenType == MINUS {
+        return n.Expr, true
+    }
+    return nil, false
+}
+
+// negate returns -expr, without a double negation
+func negate(expr ast.ASTNode) ast.ASTNode {
+    if inner, ok := negated(expr); ok {
+        return inner
+    }
+    if v, ok := value(expr); ok && v == 0 {
+        return number(0)
+    }
+    return ast.NewUnaryOperation(&token.Token{TokenType: MINUS, Value: '-', Literal: "-"}, expr)
+}
+
+// number returns a literal for a number, a negated one for a negative number since literals are never
+// negative. Whole numbers are integer literals.
+func number(v float64) ast.ASTNode {
+    if v < 0 {
+        return negate(number(-v))
+    }
+    if v == math.Trunc(v) && v <= interpreter.MaxInt {
+        literal := strconv.Itoa(int(v))
+        node, _ := ast.NewNumberLiteral(&token.Token{TokenType: INTEGER, Value: int(v), Literal: literal})
+        return node
+    }
+    literal := strconv.FormatFloat(v, 'f', -1, 64)
+    node, _ := ast.NewFloatLiteral(&token.Token{TokenType: FLOAT, Value: v, Literal: literal})
+    return node
+}
+
+var symbols = map[string]rune{PLUS: '+', MINUS: '-', MUL: '*', DIV: '/', POWER: '^'}
+
+func binary(operator string, u, v ast.ASTNode) ast.ASTNode {
+    symbol := symbols[operator]
+    return ast.NewBinaryOperation(u, v, &token.Token{TokenType: operator, Value: symbol, Literal: string(symbol)})
+}
+
+func call(name string, argument ast.ASTNode) ast.ASTNode {
+    return ast.NewCall(&token.Token{TokenType: IDENT, Value: name, Literal: name}, name, []ast.ASTNode{argument})
+}
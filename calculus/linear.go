@@ -0,0 +1,279 @@
+package calculus
+
+/*
+
+Systems of linear equations. Each side of an equation is walked into coefficient form, a sum of unknowns
+times rational coefficients plus a constant; the unknowns are the names that are not variables, mathematical
+constants or ans, and any part of an equation without unknowns (2 * pi, sqrt(2), a variable) is evaluated by
+the interpreter. The physical constants and i are unknowns like any other letter, so c and i can be solved
+for. The system is
+then solved by Gauss-Jordan elimination on big.Rat, so it is exact as long as the numbers were: ints stay
+exact and a float is taken as the decimal it prints as (0.1 is 1/10).
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/interpreter"
+    "errors"
+    "fmt"
+    "math/big"
+    "strconv"
+    "strings"
+)
+
+// ErrInconsistent is returned for a system with no solution, and ErrSingular for one with infinitely many
+var (
+    ErrInconsistent = errors.New("calculus.SolveLinear(): inconsistent system, the equations contradict each other")
+    ErrSingular     = errors.New("calculus.SolveLinear(): singular system, the equations do not determine every unknown")
+)
+
+// Solution is the solution of a linear system: the unknowns in the order they first appear and their values.
+// Exact is false if a float was involved, so the values are only as exact as floats.
+type Solution struct {
+    Names  []string
+    Values []*big.Rat
+    Exact  bool
+}
+
+// linear is one side of an equation in coefficient form
+type linear struct {
+    coefficients map[string]*big.Rat
+    constant     *big.Rat
+}
+
+// system collects the unknowns of the equations while they are walked
+type system struct {
+    interp *interpreter.Interpreter
+    names  []string
+    exact  bool
+}
+
+// SolveLinear solves a system of linear equations. A term that is not linear in the unknowns (x * y, x^2,
+// sin(x)) is an error naming the equation it is in; a system without a solution is ErrInconsistent and one
+// that does not determine all unknowns ErrSingular, which names the ones left free.
+func SolveLinear(interp *interpreter.Interpreter, equations []*ast.Equation) (*Solution, error) {
+    s := &system{interp: interp, exact: true}
+    interp.StartBudgets()
+    rows := make([]*linear, len(equations))
+    for i, equation := range equations {
+        left, err := s.walk(equation.Left)
+        if err == nil {
+            var right *linear
+            if right, err = s.walk(equation.Right); err == nil {
+                // left - right = 0
+                rows[i] = add(left, scale(right, big.NewRat(-1, 1)))
+            }
+        }
+        if err != nil {
+            return nil, fmt.Errorf("calculus.SolveLinear(): equation %d: %w", i + 1, err)
+        }
+    }
+    if len(s.names) == 0 {
+        return nil, fmt.Errorf("calculus.SolveLinear(): no unknowns, every name in the equations has a value")
+    }
+
+    // the augmented matrix: a row per equation, a column per unknown and the right-hand side
+    n := len(s.names)
+    matrix := make([][]*big.Rat, len(rows))
+    for i, row := range rows {
+        matrix[i] = make([]*big.Rat, n + 1)
+        for j, name := range s.names {
+            matrix[i][j] = new(big.Rat)
+            if c, ok := row.coefficients[name]; ok {
+                matrix[i][j].Set(c)
+            }
+        }
+        matrix[i][n] = new(big.Rat).Neg(row.constant)
+    }
+
+    // Gauss-Jordan elimination, pivot[j] is the row that determines unknown j or -1
+    pivot := make([]int, n)
+    rank := 0
+    for j := 0; j < n; j++ {
+        pivot[j] = -1
+        p := rank
+        for p < len(matrix) && matrix[p][j].Sign() == 0 {
+            p++
+        }
+        if p == len(matrix) {
+            continue
+        }
+        matrix[rank], matrix[p] = matrix[p], matrix[rank]
+        inverse := new(big.Rat).Inv(matrix[rank][j])
+        for k := j; k <= n; k++ {
+            matrix[rank][k].Mul(matrix[rank][k], inverse)
+        }
+        for i := range matrix {
+            if i == rank || matrix[i][j].Sign() == 0 {
+                continue
+            }
+            factor := new(big.Rat).Set(matrix[i][j])
+            for k := j; k <= n; k++ {
+                matrix[i][k].Sub(matrix[i][k], new(big.Rat).Mul(factor, matrix[rank][k]))
+            }
+        }
+        pivot[j] = rank
+        rank++
+    }
+
+    // a row left as 0 = c with c != 0 is a contradiction
+    for _, row := range matrix[rank:] {
+        if row[n].Sign() != 0 {
+            return nil, ErrInconsistent
+        }
+    }
+    if rank < n {
+        var free []string
+        for j, name := range s.names {
+            if pivot[j] < 0 {
+                free = append(free, name)
+            }
+        }
+        return nil, fmt.Errorf("%w, %s can take any value", ErrSingular, strings.Join(free, ", "))
+    }
+    solution := &Solution{Names: s.names, Values: make([]*big.Rat, n), Exact: s.exact}
+    for j := range s.names {
+        solution.Values[j] = matrix[pivot[j]][n]
+    }
+    return solution, nil
+}
+
+// walk returns the coefficient form of an expression
+func (s *system) walk(expr ast.ASTNode) (*linear, error) {
+    if !s.unknowns(expr) {
+        value, err := s.interp.ContinueNode(expr)
+        if err != nil {
+            return nil, err
+        }
+        constant, err := s.rat(value, expr)
+        if err != nil {
+            return nil, err
+        }
+        return &linear{coefficients: map[string]*big.Rat{}, constant: constant}, nil
+    }
+    switch n := expr.(type) {
+    case *ast.Variable:
+        return &linear{coefficients: map[string]*big.Rat{n.Name: big.NewRat(1, 1)}, constant: new(big.Rat)}, nil
+    case *ast.UnaryOperation:
+        if n.Postfix || n.Operator.TokenType != PLUS && n.Operator.TokenType != MINUS {
+            break
+        }
+        operand, err := s.walk(n.Expr)
+        if err != nil {
+            return nil, err
+        }
+        if n.Operator.TokenType == MINUS {
+            return scale(operand, big.NewRat(-1, 1)), nil
+        }
+        return operand, nil
+    case *ast.BinaryOperation:
+        left, err := s.walk(n.LeftChild)
+        if err != nil {
+            return nil, err
+        }
+        right, err := s.walk(n.RightChild)
+        if err != nil {
+            return nil, err
+        }
+        switch n.Operator.TokenType {
+        case PLUS:
+            return add(left, right), nil
+        case MINUS:
+            return add(left, scale(right, big.NewRat(-1, 1))), nil
+        case MUL:
+            // one side must be a constant
+            if len(left.coefficients) == 0 {
+                return scale(right, left.constant), nil
+            }
+            if len(right.coefficients) == 0 {
+                return scale(left, right.constant), nil
+            }
+        case DIV:
+            if len(right.coefficients) == 0 {
+                if right.constant.Sign() == 0 {
+                    return nil, interpreter.ErrDivisionByZero
+                }
+                return scale(left, new(big.Rat).Inv(right.constant)), nil
+            }
+        case POWER:
+            // x^1 is the only power of an unknown that is linear
+            if len(right.coefficients) == 0 && right.constant.Cmp(big.NewRat(1, 1)) == 0 {
+                return left, nil
+            }
+        }
+    }
+    return nil, fmt.Errorf("%s is not linear", interpreter.Source(expr))
+}
+
+// unknowns reports whether an expression contains an unknown, and adds the ones it has not seen yet
+func (s *system) unknowns(expr ast.ASTNode) bool {
+    found := false
+    ast.Inspect(expr, func(node ast.ASTNode) bool {
+        if v, ok := node.(*ast.Variable); ok {
+            if !s.known(v.Name) {
+                found = true
+                for _, name := range s.names {
+                    if name == v.Name {
+                        return true
+                    }
+                }
+                s.names = append(s.names, v.Name)
+            }
+        }
+        return true
+    })
+    return found
+}
+
+// known reports whether a name stands for its value rather than an unknown: a variable, ans with a result to
+// fall back on, or a constant without a unit such as pi. A physical constant (c, g, h, ...) has a unit, so
+// its letter is an unknown in a system, and so is i.
+func (s *system) known(name string) bool {
+    if _, ok := s.interp.Variables[name]; ok {
+        return true
+    }
+    if constant, ok := interpreter.LookupConstant(name); ok {
+        return constant.Unit == ""
+    }
+    return (name == "ans" || name == "_") && len(s.interp.History) > 0
+}
+
+// rat converts a value to a rational, a float as the shortest decimal that reads back as the same float
+func (s *system) rat(value interface{}, expr ast.ASTNode) (*big.Rat, error) {
+    switch v := value.(type) {
+    case int:
+        return big.NewRat(int64(v), 1), nil
+    case float64:
+        s.exact = false
+        if r, ok := new(big.Rat).SetString(strconv.FormatFloat(v, 'g', -1, 64)); ok {
+            return r, nil
+        }
+        return nil, fmt.Errorf("%s is %v, not a finite number", interpreter.Source(expr), v)
+    default:
+        return nil, fmt.Errorf("%s is not a number", interpreter.Source(expr))
+    }
+}
+
+// add returns a + b
+func add(a, b *linear) *linear {
+    sum := &linear{coefficients: map[string]*big.Rat{}, constant: new(big.Rat).Add(a.constant, b.constant)}
+    for _, l := range []*linear{a, b} {
+        for name, c := range l.coefficients {
+            if sum.coefficients[name] == nil {
+                sum.coefficients[name] = new(big.Rat)
+            }
+            sum.coefficients[name].Add(sum.coefficients[name], c)
+        }
+    }
+    return sum
+}
+
+// scale returns l * factor
+func scale(l *linear, factor *big.Rat) *linear {
+    product := &linear{coefficients: map[string]*big.Rat{}, constant: new(big.Rat).Mul(l.constant, factor)}
+    for name, c := range l.coefficients {
+        product.coefficients[name] = new(big.Rat).Mul(c, factor)
+    }
+    return product
+}
@@ -0,0 +1,223 @@
+package calculus
+
+/*
+
+Numeric solving of an equation in one unknown. Solve evaluates left - right with the interpreter at evenly
+spaced probe points of an interval. Between two probes where the sign changes a root is bracketed and found
+by the secant method, falling back to bisection whenever a secant step would leave the bracket or stops
+making progress. A probe that is closer to zero than both its neighbours without a sign change may be a root
+the graph only touches (x^2 = 0); Newton's method is tried from there. The interpreter's Limits apply to the
+whole search, not to each evaluation.
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/interpreter"
+    "errors"
+    "fmt"
+    "math"
+    "sort"
+    "strconv"
+)
+
+// ErrNoConvergence is returned when Newton's method failed to converge from a probe point that looked close
+// to a root, together with the roots that were found
+var ErrNoConvergence = errors.New("no convergence")
+
+// ErrNoSolution is also matched when no root was found at all: x^2 = -1 has no real solution
+var ErrNoSolution = errors.New("calculus.Solve(): no solution found")
+
+const (
+    probes        = 2000  // number of intervals the interval is probed in
+    maxIterations = 200   // iterations of the secant, bisection or Newton's method per root
+    tolerance     = 1e-12 // relative width of a bracket, or size of a Newton step, at which a root is found
+)
+
+// DefaultLow and DefaultHigh are the interval probed when none is given
+const (
+    DefaultLow  = -100.0
+    DefaultHigh = 100.0
+)
+
+// Solve returns the roots of the equation in [low, high] in increasing order, with the variable as the
+// unknown. The equation is evaluated by interp, with its variables and functions; the variable gets its old
+// value back, if it had one, afterwards. An error evaluating the equation at a probe point, such as
+// interpreter.ErrDivisionByZero where it is undefined, is returned with the point it happened at. If Newton's
+// method failed near a candidate root the error matches ErrNoConvergence, and the roots found elsewhere are
+// returned with it; if there are none it matches ErrNoSolution as well.
+func Solve(interp *interpreter.Interpreter, equation *ast.Equation, variable string, low, high float64) ([]float64, error) {
+    if !(low < high) {
+        return nil, fmt.Errorf("calculus.Solve(): empty interval [%v, %v]", low, high)
+    }
+    if saved, ok := interp.Variables[variable]; ok {
+        defer func() { interp.Variables[variable] = saved }()
+    } else {
+        defer delete(interp.Variables, variable)
+    }
+    interp.StartBudgets()
+    f := func(x float64) (float64, error) {
+        interp.Variables[variable] = x
+        left, err := evaluate(interp, equation.Left)
+        if err != nil {
+            return 0, fmt.Errorf("calculus.Solve(): at %s = %v: %w", variable, x, err)
+        }
+        right, err := evaluate(interp, equation.Right)
+        if err != nil {
+            return 0, fmt.Errorf("calculus.Solve(): at %s = %v: %w", variable, x, err)
+        }
+        return left - right, nil
+    }
+
+    xs := make([]float64, probes + 1)
+    fs := make([]float64, probes + 1)
+    for i := range xs {
+        xs[i] = low + (high - low) * float64(i) / probes
+        var err error
+        if fs[i], err = f(xs[i]); err != nil {
+            return nil, err
+        }
+    }
+
+    var roots []float64
+    failed := math.NaN() // a probe Newton's method did not converge from
+    for i := range xs {
+        switch {
+        case fs[i] == 0:
+            roots = append(roots, xs[i])
+        case i > 0 && fs[i - 1] != 0 && math.Signbit(fs[i - 1]) != math.Signbit(fs[i]):
+            root, err := bracketed(f, xs[i - 1], xs[i], fs[i - 1], fs[i])
+            if err != nil {
+                return nil, err
+            }
+            // a sign change across a pole (1 / x) converges to the pole, where f is far from 0
+            if value, err := f(root); err == nil && math.Abs(value) < 1e-6 {
+                roots = append(roots, root)
+            }
+        case i > 0 && i < probes && math.Abs(fs[i]) < math.Abs(fs[i - 1]) && math.Abs(fs[i]) < math.Abs(fs[i + 1]) &&
+            math.Signbit(fs[i - 1]) == math.Signbit(fs[i]) && math.Signbit(fs[i]) == math.Signbit(fs[i + 1]):
+            root, err := newton(f, xs[i], xs[i - 1], xs[i + 1])
+            if err == ErrNoConvergence {
+                if math.IsNaN(failed) {
+                    failed = xs[i]
+                }
+                continue
+            }
+            if err != nil {
+                return nil, err
+            }
+            roots = append(roots, root)
+        }
+    }
+    roots = distinct(roots, math.Max(math.Abs(low), math.Abs(high)))
+    if math.IsNaN(failed) {
+        return roots, nil
+    }
+    if len(roots) == 0 {
+        return nil, fmt.Errorf("%w in [%v, %v]: %w near %s = %v", ErrNoSolution, low, high, ErrNoConvergence, variable,
+            failed)
+    }
+    return roots, fmt.Errorf("calculus.Solve(): %w near %s = %v", ErrNoConvergence, variable, failed)
+}
+
+// evaluate evaluates an expression to a float, on the budgets of the whole search
+func evaluate(interp *interpreter.Interpreter, expr ast.ASTNode) (float64, error) {
+    result, err := interp.ContinueNode(expr)
+    if err != nil {
+        return 0, err
+    }
+    switch value := result.(type) {
+    case int:
+        return float64(value), nil
+    case float64:
+        return value, nil
+    default:
+        return 0, fmt.Errorf("%s is not a number", interpreter.Source(expr))
+    }
+}
+
+// bracketed finds the root of f between a and b, where f has opposite signs
+func bracketed(f func(float64) (float64, error), a, b, fa, fb float64) (float64, error) {
+    for i := 0; i < maxIterations; i++ {
+        width := b - a
+        x := b - fb * (b - a) / (fb - fa) // secant step
+        if i % 3 == 2 || !(x > a && x < b) {
+            x = a + (b - a) / 2 // bisection, at least every third step, so the bracket always shrinks
+        }
+        fx, err := f(x)
+        if err != nil {
+            return 0, err
+        }
+        if fx == 0 {
+            return x, nil
+        }
+        if math.Signbit(fx) == math.Signbit(fa) {
+            a, fa = x, fx
+        } else {
+            b, fb = x, fx
+        }
+        if b - a <= tolerance * math.Max(1, math.Abs(x)) || b - a == width {
+            break
+        }
+    }
+    if math.Abs(fa) < math.Abs(fb) {
+        return a, nil
+    }
+    return b, nil
+}
+
+// newton finds a root of f with Newton's method starting at x, using a numerical derivative. The root must
+// lie between low and high.
+func newton(f func(float64) (float64, error), x, low, high float64) (float64, error) {
+    for i := 0; i < maxIterations; i++ {
+        fx, err := f(x)
+        if err != nil {
+            return 0, err
+        }
+        if fx == 0 {
+            return x, nil
+        }
+        h := 1e-7 * math.Max(1, math.Abs(x))
+        fh, err := f(x + h)
+        if err != nil {
+            return 0, err
+        }
+        slope := (fh - fx) / h
+        if slope == 0 {
+            break
+        }
+        step := fx / slope
+        x -= step
+        if x < low || x > high {
+            break
+        }
+        if math.Abs(step) <= tolerance * math.Max(1, math.Abs(x)) {
+            if value, err := f(x); err == nil && math.Abs(value) < 1e-9 {
+                return x, nil
+            }
+            break
+        }
+    }
+    return 0, ErrNoConvergence
+}
+
+// distinct sorts the roots, rounds them to 12 significant figures (so 2 is not 1.9999999999999998), or to 0
+// if they are that close to it on the scale of the interval, and removes the ones found twice
+func distinct(roots []float64, scale float64) []float64 {
+    sort.Float64s(roots)
+    result := make([]float64, 0, len(roots))
+    for _, root := range roots {
+        rounded, err := strconv.ParseFloat(strconv.FormatFloat(root, 'g', 12, 64), 64)
+        if err != nil {
+            rounded = root
+        }
+        if math.Abs(rounded) < 1e-9 * scale {
+            rounded = 0
+        }
+        if len(result) > 0 && math.Abs(rounded - result[len(result) - 1]) <= 1e-9 * math.Max(1, math.Abs(rounded)) {
+            continue
+        }
+        result = append(result, rounded)
+    }
+    return result
+}
@@ -0,0 +1,66 @@
+package main
+
+/*
+
+The configuration file, read when the calculator starts. It has the same layout as a session file (see
+session.go) with two sections:
+
+    [settings]
+    notation = sci
+    places = 3
+    [definitions]
+    rate = 0.07
+    tax(x) = x * rate
+
+Settings are the ones :set takes. Each line of [definitions] is parsed by the calculator itself and may
+hold assignments and function definitions, one line at a time. An error names the file and the line it is
+on. The file is calculator/config in the user's config directory (~/.config/calculator/config on Linux),
+or the one given with -config.
+
+*/
+
+import (
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+)
+
+var configSections = []string{"settings", "definitions"}
+
+// configPath returns the default location of the configuration file
+func configPath() (string, error) {
+    dir, err := os.UserConfigDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(dir, "calculator", "config"), nil
+}
+
+// configure applies the configuration file at path to the session. With an empty path the file at the
+// default location is read if there is one; "none" reads no file.
+func (s *session) configure(path string) error {
+    if path == "none" {
+        return nil
+    }
+    if path == "" {
+        defaultPath, err := configPath()
+        if err != nil {
+            return nil // no config directory, so no configuration file
+        }
+        if _, err := os.Stat(defaultPath); errors.Is(err, fs.ErrNotExist) {
+            return nil
+        }
+        path = defaultPath
+    }
+    return readSections(path, configSections, func(section, line string) error {
+        if section == "definitions" {
+            return s.define(line, true)
+        }
+        name, value, err := splitPair(line)
+        if err != nil {
+            return err
+        }
+        return s.set(name, value)
+    })
+}
@@ -0,0 +1,269 @@
+package format
+
+/*
+
+Formats the results of the interpreter for display. Options select the base integers are written in, a fixed
+number of decimal places or significant figures, thousands grouping, and plain, scientific or engineering
+notation. Options can be set from their names and string values (as typed after :set in the REPL or given on
+the command line) with Set.
+
+*/
+
+import (
+    "calculator/interpreter"
+    "fmt"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+type Notation int
+
+const (
+    Plain       Notation = iota // 1234.5
+    Scientific                  // 1.2345e+03
+    Engineering                 // 1.2345e+03, with the exponent a multiple of 3 (12.3e+03)
+)
+
+var notationNames = map[Notation]string{Plain: "plain", Scientific: "sci", Engineering: "eng"}
+
+func (n Notation) String() string {
+    return notationNames[n]
+}
+
+type Options struct {
+    Base     int      // 2, 8, 10 or 16, other bases than 10 only apply to integers
+    Places   int      // number of decimal places, -1 for as many as needed
+    SigFigs  int      // number of significant figures, 0 for as many as needed. Takes precedence over Places
+    Group    bool     // group the digits before the decimal point in thousands (1,234,567)
+    Notation Notation
+    Word     *interpreter.WordSize // word size integers are written for in programmer mode, nil otherwise
+}
+
+// Default returns the options that print values the way fmt's %v does
+func Default() Options {
+    return Options{Base: 10, Places: -1}
+}
+
+// Value formats a result of the interpreter: an int, float64, complex128, bool, matrix, list or quantity.
+// Other values are written with %v.
+func Value(value interface{}, opts Options) string {
+    switch v := value.(type) {
+    case int:
+        return Integer(v, opts)
+    case float64:
+        return Float(v, opts)
+    case complex128:
+        return Complex(v, opts)
+    case *interpreter.Matrix:
+        return v.Format(func(element interface{}) string { return Value(element, opts) })
+    case *interpreter.List:
+        return v.Format(func(element interface{}) string { return Value(element, opts) })
+    case *interpreter.Quantity:
+        return v.Format(func(number float64) string { return Float(number, opts) })
+    default:
+        return fmt.Sprintf("%v", value)
+    }
+}
+
+// Integer formats an integer. In a base other than 10 it is written with a 0b, 0o or 0x prefix; decimal
+// integers are only given decimal places, significant figures or an exponent if the options ask for them.
+func Integer(value int, opts Options) string {
+    if opts.Base != 0 && opts.Base != 10 {
+        if opts.Word != nil {
+            return opts.Word.Format(value, opts.Base)
+        }
+        return interpreter.FormatInteger(value, opts.Base)
+    }
+    if opts.Word != nil && !opts.Word.Signed {
+        return opts.Word.Format(value, 10) // uint64 values above the int range are stored as negative ints
+    }
+    if opts.SigFigs > 0 || opts.Notation != Plain || opts.Places > 0 {
+        return Float(float64(value), opts)
+    }
+    return group(strconv.Itoa(value), opts.Group)
+}
+
+// Complex formats a complex number as a + bi, each part as a float. A real part of 0 is left out (2i), and
+// so is an imaginary part of 1 (1 + i).
+func Complex(value complex128, opts Options) string {
+    sign, im := " + ", imag(value)
+    if math.Signbit(im) {
+        sign, im = " - ", -im
+    }
+    coefficient := Float(im, opts)
+    if coefficient == "1" {
+        coefficient = ""
+    }
+    if real(value) == 0 {
+        return strings.TrimPrefix(strings.TrimSpace(sign), "+") + coefficient + "i"
+    }
+    return Float(real(value), opts) + sign + coefficient + "i"
+}
+
+// Float formats a floating point number
+func Float(value float64, opts Options) string {
+    if math.IsNaN(value) || math.IsInf(value, 0) {
+        return strconv.FormatFloat(value, 'g', -1, 64)
+    }
+    switch opts.Notation {
+    case Scientific:
+        precision := opts.Places
+        if opts.SigFigs > 0 {
+            precision = opts.SigFigs - 1
+        }
+        return strconv.FormatFloat(value, 'e', precision, 64)
+    case Engineering:
+        return engineering(value, opts)
+    }
+    if opts.SigFigs > 0 {
+        value = roundSignificant(value, opts.SigFigs)
+        exponent := 0
+        if value != 0 {
+            exponent = int(math.Floor(math.Log10(math.Abs(value))))
+        }
+        places := opts.SigFigs - 1 - exponent
+        if places < 0 {
+            places = 0
+        }
+        return group(strconv.FormatFloat(value, 'f', places, 64), opts.Group)
+    }
+    if opts.Places < 0 {
+        // the shortest form, with an exponent for very large or small numbers, as %v writes it
+        return group(strconv.FormatFloat(value, 'g', -1, 64), opts.Group)
+    }
+    return group(strconv.FormatFloat(value, 'f', opts.Places, 64), opts.Group)
+}
+
+// roundSignificant rounds a value to the given number of significant figures
+func roundSignificant(value float64, figures int) float64 {
+    rounded, err := strconv.ParseFloat(strconv.FormatFloat(value, 'e', figures - 1, 64), 64)
+    if err != nil {
+        return value
+    }
+    return rounded
+}
+
+// engineering writes a value as a mantissa in [1, 1000) and an exponent that is a multiple of 3. The 
+// mantissa is made by moving the decimal point of the scientific form, so no rounding error is introduced;
+// only a fixed number of decimal places needs the mantissa to be rounded again. 
+func engineering(value float64, opts Options) string {
+    precision := -1
+    if opts.SigFigs > 0 {
+        precision = opts.SigFigs - 1
+    }
+    scientific := strconv.FormatFloat(value, 'e', precision, 64) // -1.2345e+04
+    e := strings.IndexByte(scientific, 'e')
+    mantissa := scientific[:e]
+    exponent, _ := strconv.Atoi(scientific[e + 1:])
+    sign := ""
+    if strings.HasPrefix(mantissa, "-") {
+        sign, mantissa = "-", mantissa[1:]
+    }
+    shift := (exponent % 3 + 3) % 3 // digits moved before the decimal point
+    exponent -= shift
+    digits := strings.Replace(mantissa, ".", "", 1)
+    for len(digits) < shift + 1 {
+        digits += "0"
+    }
+    mantissa = digits[:shift + 1]
+    if len(digits) > shift + 1 {
+        mantissa += "." + digits[shift + 1:]
+    }
+    if opts.SigFigs == 0 && opts.Places >= 0 {
+        m, _ := strconv.ParseFloat(mantissa, 64)
+        mantissa = strconv.FormatFloat(m, 'f', opts.Places, 64)
+    }
+    return fmt.Sprintf("%s%se%+03d", sign, mantissa, exponent)
+}
+
+// group inserts a comma between each group of three digits before the decimal point
+func group(number string, enabled bool) string {
+    if !enabled {
+        return number
+    }
+    sign := ""
+    if strings.HasPrefix(number, "-") {
+        sign, number = "-", number[1:]
+    }
+    integer, fraction := number, ""
+    if i := strings.IndexAny(number, ".e"); i >= 0 {
+        integer, fraction = number[:i], number[i:]
+    }
+    var grouped strings.Builder
+    for i, digit := range integer {
+        if i > 0 && (len(integer) - i) % 3 == 0 {
+            grouped.WriteByte(',')
+        }
+        grouped.WriteRune(digit)
+    }
+    return sign + grouped.String() + fraction
+}
+
+// Set changes the option with the given name: base (2, 8, 10, 16), places (a number, or "auto"), sigfigs
+// (a number, or "auto"), group (on or off) or notation (plain, sci or eng)
+func (opts *Options) Set(name, value string) error {
+    switch name {
+    case "base":
+        base, err := strconv.Atoi(value)
+        if err != nil || base != 2 && base != 8 && base != 10 && base != 16 {
+            return fmt.Errorf("format.Set(): base must be 2, 8, 10 or 16, not %s", value)
+        }
+        opts.Base = base
+    case "places", "sigfigs":
+        number := -1
+        if value != "auto" {
+            var err error
+            if number, err = strconv.Atoi(value); err != nil || number < 0 {
+                return fmt.Errorf("format.Set(): %s must be a number or auto, not %s", name, value)
+            }
+        }
+        if name == "places" {
+            opts.Places = number
+        } else {
+            opts.SigFigs = max(number, 0)
+        }
+    case "group":
+        switch value {
+        case "on":
+            opts.Group = true
+        case "off":
+            opts.Group = false
+        default:
+            return fmt.Errorf("format.Set(): group must be on or off, not %s", value)
+        }
+    case "notation":
+        for notation, notationName := range notationNames {
+            if notationName == value {
+                opts.Notation = notation
+                return nil
+            }
+        }
+        return fmt.Errorf("format.Set(): notation must be plain, sci or eng, not %s", value)
+    default:
+        return fmt.Errorf("format.Set(): unknown setting: %s", name)
+    }
+    return nil
+}
+
+// Settings returns the options as name=value pairs that Set accepts, sorted by name
+func (opts Options) Settings() []string {
+    onOff := map[bool]string{true: "on", false: "off"}
+    places, sigfigs := "auto", "auto"
+    if opts.Places >= 0 {
+        places = strconv.Itoa(opts.Places)
+    }
+    if opts.SigFigs > 0 {
+        sigfigs = strconv.Itoa(opts.SigFigs)
+    }
+    settings := []string{
+        "base=" + strconv.Itoa(opts.Base),
+        "places=" + places,
+        "sigfigs=" + sigfigs,
+        "group=" + onOff[opts.Group],
+        "notation=" + opts.Notation.String(),
+    }
+    sort.Strings(settings)
+    return settings
+}
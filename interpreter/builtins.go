@@ -0,0 +1,83 @@
+package interpreter
+
+/*
+
+The built-in functions: the usual functions of one number, computed on floats. They are called like user
+functions (sin(x)), but cannot be redefined.
+
+*/
+
+import (
+    "calculator/ast"
+    "fmt"
+    "math"
+)
+
+var builtins = map[string]func(float64) float64{
+    "sin":  math.Sin,
+    "cos":  math.Cos,
+    "tan":  math.Tan,
+    "asin": math.Asin,
+    "acos": math.Acos,
+    "atan": math.Atan,
+    "exp":  math.Exp,
+    "ln":   math.Log,
+    "log":  math.Log10,
+    "sqrt": math.Sqrt,
+    "abs":  math.Abs,
+}
+
+// commands are handled by the REPL on the expression given as their argument rather than its value (see 
+// session.calculus in main.go): they are reserved like the built-in functions, but have no value here
+var commands = map[string]bool{
+    "diff":  true,
+    "solve": true,
+}
+
+// IsBuiltin reports whether name is a built-in function, of a number, of a matrix (see matrix.go), an
+// aggregate (see lists.go), a statistic (see statistics.go) or of the parts of a complex number (see complex.go),
+// or one of the REPL commands diff and solve
+func IsBuiltin(name string) bool {
+    _, ok := builtins[name]
+    _, matrix := matrixBuiltins[name]
+    _, aggregate := listBuiltins[name]
+    _, statistic := statisticsBuiltins[name]
+    _, parts := complexBuiltins[name]
+    return ok || matrix || aggregate || statistic || parts || commands[name]
+}
+
+// callBuiltin applies a built-in function to its evaluated arguments. A result that is not a number, or is
+// infinite for a finite argument (ln(0)), is outside the function's domain and an error, unless Complex is on
+// and the function has a complex value there (sqrt(-1) is i). abs keeps ints.
+func (interp *Interpreter) callBuiltin(node *ast.Call, arguments []interface{}) (interface{}, error) {
+    if len(arguments) != 1 {
+        return nil, fmt.Errorf("interpreter: %s takes 1 argument, not %d at column %d", node.Name, len(arguments),
+            node.Token.Position + 1)
+    }
+    var x float64
+    switch value := arguments[0].(type) {
+    case int:
+        if node.Name == "abs" && value != MinInt {
+            if value < 0 {
+                return -value, nil
+            }
+            return value, nil
+        }
+        x = float64(value)
+    case float64:
+        x = value
+    case complex128:
+        return callComplexFunction(node, value)
+    default:
+        return nil, fmt.Errorf("interpreter: type error: cannot apply %s to %s at column %d", node.Name,
+            typeName(arguments[0]), node.Token.Position + 1)
+    }
+    result := builtins[node.Name](x)
+    if interp.Complex && math.IsNaN(result) && !math.IsNaN(x) {
+        return callComplexFunction(node, complex(x, 0))
+    }
+    if math.IsNaN(result) && !math.IsNaN(x) || math.IsInf(result, 0) && !math.IsInf(x, 0) {
+        return nil, fmt.Errorf("interpreter: %s(%v) has no finite value at column %d", node.Name, x, node.Token.Position + 1)
+    }
+    return result, nil
+}
@@ -0,0 +1,184 @@
+package interpreter
+
+/*
+
+Complex numbers: an imaginary literal, 2i or 0.5i, or i on its own unless a variable i is defined (so loops
+over i keep working). They combine with ints and floats under + - * / ^ == and !=, and a result whose
+imaginary part is 0 is a float again, so i * i is -1. Integer powers are computed by repeated
+multiplication, so i^2 is exactly -1 too. The built-in functions of a number take complex arguments as well,
+abs of a complex number is its modulus, and re, im, arg and conj take any number.
+
+With Complex on the interpreter, the functions and powers that have no real value for a real argument
+(sqrt(-1), ln(-2), (-8)^(1/3)) return their principal complex value instead of an error.
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/token"
+    "fmt"
+    "math"
+    "math/cmplx"
+)
+
+// Visit ImaginaryLiteral: return the complex value of the node
+func (interp *Interpreter) VisitImaginaryLiteral(il *ast.ImaginaryLiteral) (interface{}, error) {
+    return complex(0, il.Value), nil
+}
+
+// toComplex returns a number as a complex number
+func toComplex(number interface{}) (complex128, bool) {
+    switch v := number.(type) {
+    case int:
+        return complex(float64(v), 0), true
+    case float64:
+        return complex(v, 0), true
+    case complex128:
+        return v, true
+    }
+    return 0, false
+}
+
+// simplified returns a complex number with no imaginary part as a float
+func simplified(c complex128) interface{} {
+    if imag(c) == 0 {
+        return real(c)
+    }
+    return c
+}
+
+// complexOperands reports whether a binary operator is applied to numbers as complex numbers: if one of them
+// is complex, or, with Complex on, if the operator is a power of a negative number that has no real value
+func (interp *Interpreter) complexOperands(operator *token.Token, leftResult, rightResult interface{}) bool {
+    left, leftOk := toComplex(leftResult)
+    _, rightOk := toComplex(rightResult)
+    if !leftOk || !rightOk {
+        return false
+    }
+    _, leftComplex := leftResult.(complex128)
+    _, rightComplex := rightResult.(complex128)
+    if leftComplex || rightComplex {
+        return true
+    }
+    exponent, isFloat := rightResult.(float64)
+    return interp.Complex && operator.TokenType == POWER && isFloat && real(left) < 0 && exponent != math.Trunc(exponent)
+}
+
+// complexOperation applies an arithmetic operator, == or != to two numbers as complex numbers. Complex numbers
+// are not ordered, so < and the others are type errors naming the operands as they were, (1 + i) < 2 is one
+// on complex and int.
+func complexOperation(operator *token.Token, leftResult, rightResult interface{}) (interface{}, error) {
+    leftValue, _ := toComplex(leftResult)
+    rightValue, _ := toComplex(rightResult)
+    switch operator.TokenType {
+    case PLUS:
+        return simplified(leftValue + rightValue), nil
+    case MINUS:
+        return simplified(leftValue - rightValue), nil
+    case MUL:
+        return simplified(leftValue * rightValue), nil
+    case DIV:
+        if rightValue == 0 {
+            return nil, ErrDivisionByZero
+        }
+        return simplified(leftValue / rightValue), nil
+    case POWER:
+        if leftValue == 0 && real(rightValue) < 0 {
+            return nil, ErrDivisionByZero
+        }
+        if exponent := real(rightValue); imag(rightValue) == 0 && exponent == math.Trunc(exponent) &&
+            math.Abs(exponent) <= MaxInt {
+            return simplified(complexPower(leftValue, int64(exponent))), nil
+        }
+        return simplified(cmplx.Pow(leftValue, rightValue)), nil
+    case EQ:
+        return leftValue == rightValue, nil
+    case NE:
+        return leftValue != rightValue, nil
+    default:
+        return nil, typeError(operator, leftResult, rightResult)
+    }
+}
+
+// complexPower raises a complex number to an integer power by repeated squaring
+func complexPower(base complex128, exponent int64) complex128 {
+    if exponent < 0 {
+        return 1 / complexPower(base, -exponent)
+    }
+    result := complex(1, 0)
+    for ; exponent > 0; exponent >>= 1 {
+        if exponent & 1 == 1 {
+            result *= base
+        }
+        base *= base
+    }
+    return result
+}
+
+// the built-in functions of a complex number
+var complexFunctions = map[string]func(complex128) complex128{
+    "sin":  cmplx.Sin,
+    "cos":  cmplx.Cos,
+    "tan":  cmplx.Tan,
+    "asin": cmplx.Asin,
+    "acos": cmplx.Acos,
+    "atan": cmplx.Atan,
+    "exp":  cmplx.Exp,
+    "ln":   cmplx.Log,
+    "log":  cmplx.Log10,
+    "sqrt": cmplx.Sqrt,
+}
+
+// callComplexFunction applies a built-in function to a complex number. abs is the modulus.
+func callComplexFunction(node *ast.Call, c complex128) (interface{}, error) {
+    if node.Name == "abs" {
+        return cmplx.Abs(c), nil
+    }
+    result := complexFunctions[node.Name](c)
+    if cmplx.IsNaN(result) || cmplx.IsInf(result) {
+        return nil, fmt.Errorf("interpreter: %s(%v) has no finite value at column %d", node.Name, c, node.Token.Position + 1)
+    }
+    return simplified(result), nil
+}
+
+// the functions of the parts of a number, which take ints and floats too
+var complexBuiltins = map[string]func(number interface{}, c complex128) interface{}{
+    "re": func(number interface{}, c complex128) interface{} {
+        if _, ok := number.(complex128); ok {
+            return real(c)
+        }
+        return number
+    },
+    "im": func(number interface{}, c complex128) interface{} {
+        switch number.(type) {
+        case int:
+            return 0
+        case float64:
+            return 0.0
+        }
+        return imag(c)
+    },
+    "arg": func(number interface{}, c complex128) interface{} {
+        return cmplx.Phase(c)
+    },
+    "conj": func(number interface{}, c complex128) interface{} {
+        if _, ok := number.(complex128); ok {
+            return cmplx.Conj(c)
+        }
+        return number
+    },
+}
+
+// callComplexBuiltin applies re, im, arg or conj to its evaluated argument
+func (interp *Interpreter) callComplexBuiltin(node *ast.Call, arguments []interface{}) (interface{}, error) {
+    if len(arguments) != 1 {
+        return nil, fmt.Errorf("interpreter: %s takes 1 argument, not %d at column %d", node.Name, len(arguments),
+            node.Token.Position + 1)
+    }
+    c, ok := toComplex(arguments[0])
+    if !ok {
+        return nil, fmt.Errorf("interpreter: type error: cannot apply %s to %s at column %d", node.Name,
+            typeName(arguments[0]), node.Token.Position + 1)
+    }
+    return complexBuiltins[node.Name](arguments[0], c), nil
+}
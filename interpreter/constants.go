@@ -0,0 +1,82 @@
+package interpreter
+
+/*
+
+The table of named constants the interpreter consults for identifiers before it looks at variables. Constants
+are read-only: assigning to one, or using one as a loop variable, is an error. Besides the mathematical and
+physical constants below, programs embedding the calculator can add their own (tax rates, conversion
+factors, ...) with RegisterConstant.
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/lexer"
+    "fmt"
+    "math"
+    "sort"
+)
+
+// Constant is a named value with the unit it is given in ("" for a pure number) and a short description
+type Constant struct {
+    Name        string
+    Value       float64
+    Unit        string
+    Description string
+}
+
+// physical constants are the CODATA 2018 values, exact where the SI defines them
+var constants = map[string]Constant{
+    "pi":   {"pi", math.Pi, "", "ratio of a circle's circumference to its diameter"},
+    "e":    {"e", math.E, "", "base of the natural logarithm"},
+    "tau":  {"tau", 2 * math.Pi, "", "ratio of a circle's circumference to its radius"},
+    "phi":  {"phi", math.Phi, "", "golden ratio"},
+    "c":    {"c", 299792458, "m/s", "speed of light in vacuum"},
+    "g":    {"g", 9.80665, "m/s^2", "standard acceleration of gravity"},
+    "G":    {"G", 6.67430e-11, "m^3/(kg s^2)", "Newtonian constant of gravitation"},
+    "h":    {"h", 6.62607015e-34, "J s", "Planck constant"},
+    "hbar": {"hbar", 6.62607015e-34 / (2 * math.Pi), "J s", "reduced Planck constant"},
+    "kB":   {"kB", 1.380649e-23, "J/K", "Boltzmann constant"},
+    "NA":   {"NA", 6.02214076e23, "1/mol", "Avogadro constant"},
+    "R":    {"R", 8.314462618, "J/(mol K)", "molar gas constant"},
+    "qe":   {"qe", 1.602176634e-19, "C", "elementary charge"},
+    "me":   {"me", 9.1093837015e-31, "kg", "electron mass"},
+    "mp":   {"mp", 1.67262192369e-27, "kg", "proton mass"},
+}
+
+// RegisterConstant adds a constant to the table, or replaces one that was registered before. The name must be
+// a valid variable name (not a keyword). It is meant to be called at startup, before anything is evaluated.
+func RegisterConstant(name string, value float64, unit, description string) error {
+    lex := lexer.NewLexer(name)
+    first, err := lex.GetNextToken()
+    if err != nil || first.TokenType != IDENT || first.Literal != name {
+        return fmt.Errorf("interpreter.RegisterConstant(): invalid constant name: %q", name)
+    }
+    constants[name] = Constant{Name: name, Value: value, Unit: unit, Description: description}
+    return nil
+}
+
+// LookupConstant returns the constant with the given name
+func LookupConstant(name string) (Constant, bool) {
+    constant, ok := constants[name]
+    return constant, ok
+}
+
+// Constants returns all constants sorted by name
+func Constants() []Constant {
+    list := make([]Constant, 0, len(constants))
+    for _, constant := range constants {
+        list = append(list, constant)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+    return list
+}
+
+// readOnly reports an error if the variable is a constant and cannot be assigned
+func readOnly(variable *ast.Variable) error {
+    if _, ok := constants[variable.Name]; ok {
+        return fmt.Errorf("interpreter: cannot assign to constant %s at column %d", variable.Name,
+            variable.Token.Position + 1)
+    }
+    return nil
+}
@@ -8,7 +8,11 @@ package interpreter
 import (
     "calculator/parser"
     "calculator/ast"
+    "calculator/token"
+    "context"
+    "errors"
     "fmt"
+    "math"
 )
 
 const (
@@ -20,86 +24,677 @@ const (
     LPAR    = "LPAR"
     RPAR    = "RPAR"
     EOF     = "EOF"
+    BOOLEAN = "BOOLEAN"
+    EQ      = "EQ"
+    NE      = "NE"
+    LT      = "LT"
+    LE      = "LE"
+    GT      = "GT"
+    GE      = "GE"
+    AND     = "AND"
+    OR      = "OR"
+    NOT     = "NOT"
+    IDENT   = "IDENT"
+    QUESTION = "QUESTION"
+    COLON   = "COLON"
+    IF      = "IF"
+    THEN    = "THEN"
+    ELSE    = "ELSE"
+    SEMI    = "SEMI"
+    LBRACE  = "LBRACE"
+    RBRACE  = "RBRACE"
+    ASSIGN  = "ASSIGN"
+    DOTDOT  = "DOTDOT"
+    WHILE   = "WHILE"
+    FOR     = "FOR"
+    IN      = "IN"
+    BREAK   = "BREAK"
+    BITAND  = "BITAND"
+    BITOR   = "BITOR"
+    CARET   = "CARET"
+    TILDE   = "TILDE"
+    SHL     = "SHL"
+    SHR     = "SHR"
+    FLOAT   = "FLOAT"
+    PERCENT = "PERCENT"
+    FACTORIAL = "FACTORIAL"
+    HISTORY = "HISTORY"
+    COMMA   = "COMMA"
+    POWER   = "POWER"
+    LBRACKET = "LBRACKET"
+    RBRACKET = "RBRACKET"
+    DOTMUL  = "DOTMUL"
 )
 
 
+// Integer results are kept within the same 32-bit range the lexer accepts for literals
+const (
+    MinInt = math.MinInt32
+    MaxInt = math.MaxInt32
+)
+
+// OverflowMode selects what happens when an integer operation leaves the [MinInt, MaxInt] range 
+type OverflowMode int
+
+const (
+    OverflowCheck    OverflowMode = iota // return an OverflowError (the default)
+    OverflowWrap                         // wrap around like two's complement hardware
+    OverflowSaturate                     // clamp the result to MinInt or MaxInt
+)
+
+var overflowModeNames = []string{OverflowCheck: "error", OverflowWrap: "wrap", OverflowSaturate: "saturate"}
+
+func (mode OverflowMode) String() string {
+    return overflowModeNames[mode]
+}
+
+// ParseOverflowMode converts the name of an overflow mode (error, wrap or saturate) to an OverflowMode
+func ParseOverflowMode(name string) (OverflowMode, error) {
+    switch name {
+    case "error":
+        return OverflowCheck, nil
+    case "wrap":
+        return OverflowWrap, nil
+    case "saturate":
+        return OverflowSaturate, nil
+    default:
+        return OverflowCheck, fmt.Errorf("interpreter.ParseOverflowMode(): unknown overflow mode: %s", name)
+    }
+}
+
+// ErrOverflow is matched (with errors.Is) by every OverflowError
+var ErrOverflow = errors.New("integer overflow")
+
+// OverflowError reports the operation that overflowed and the position of its operator in the input
+type OverflowError struct {
+    Operation string
+    Position  int
+}
+
+func (e *OverflowError) Error() string {
+    return fmt.Sprintf("interpreter: integer overflow: %s at column %d", e.Operation, e.Position + 1)
+}
+
+func (e *OverflowError) Is(target error) bool {
+    return target == ErrOverflow
+}
+
+// ErrDivisionByZero is returned for a division by zero, and anything else that is a division by zero in 
+// disguise (0^-1)
+var ErrDivisionByZero = errors.New("interpreter.VisitBinaryOperatrion(): division by zero")
+
+// ErrLimitExceeded is matched (with errors.Is) by every error returned when an evaluation budget runs out
+var ErrLimitExceeded = errors.New("evaluation limit exceeded")
+
+// Limits bounds the cost of a single evaluation. A zero value for any field means no limit. 
+type Limits struct {
+    MaxNodes int // maximum number of nodes in the AST returned by the parser
+    MaxSteps int // maximum number of nodes visited while evaluating
+    MaxDepth int // maximum nesting depth of the traversal
+    MaxIterations int // maximum number of loop iterations, across all loops
+    MaxRecursion int // maximum nesting of calls of user-defined functions
+}
+
+// DefaultMaxIterations is the iteration limit of a new interpreter, so a runaway loop always ends 
+const DefaultMaxIterations = 1000000
+
+// DefaultMaxRecursion is the recursion limit of a new interpreter, so a function that calls itself forever
+// is an error rather than a crash of the whole program when the Go stack runs out
+const DefaultMaxRecursion = 1000
+
+// errBreak is returned by VisitBreak and travels up through the visit methods to the innermost loop
+var errBreak = errors.New("break outside of a loop")
+
+// LimitError reports which budget was exceeded and what its limit was 
+type LimitError struct {
+    Limit string
+    Max   int
+}
+
+func (e *LimitError) Error() string {
+    return fmt.Sprintf("interpreter: %s limit exceeded (max %d)", e.Limit, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+    return target == ErrLimitExceeded
+}
+
 // the interpreter 
 type Interpreter struct {
-    Parser *parser.Parser
+    Parser    *parser.Parser
+    Limits    Limits
+    Overflow  OverflowMode
+    Word      *WordSize // programmer mode: integers wrap at the width of this word, nil for the default mode
+    Rational  bool      // compute the statistics functions exactly, see statistics.go
+    Complex   bool      // sqrt(-1) and the like are complex rather than errors, see complex.go
+    Variables map[string]interface{} // values of variables (int, float64, bool, complex128, *List, *Matrix or *Quantity), assignments are stored here too
+    History   []interface{}          // results of earlier evaluations, oldest first: $1, $2, ... and ans or _ for the last
+    Functions map[string]*ast.FunctionDefinition // functions defined so far
+    ctx    context.Context // checked for cancellation before each node is visited
+    steps  int             // number of nodes visited so far
+    depth  int             // current nesting depth of the traversal
+    iterations int         // number of loop iterations so far
+    calls  int             // current nesting of user function calls
+    trace  *Tracer         // told about every node that is evaluated while a Tracer is running, see trace.go
 }
 
 func NewInterpreter(parser *parser.Parser) *Interpreter { 
-    return &Interpreter{Parser: parser}
+    return &Interpreter{
+        Parser: parser,
+        Limits: Limits{MaxIterations: DefaultMaxIterations, MaxRecursion: DefaultMaxRecursion},
+        Variables: make(map[string]interface{}),
+        Functions: make(map[string]*ast.FunctionDefinition),
+        ctx: context.Background(),
+    }
 }
 
+// visit evaluates a node on behalf of its parent, enforcing cancellation and the step and depth budgets. 
+// All recursive evaluation goes through here rather than calling Accept() directly. 
+func (interp *Interpreter) visit(node ast.ASTNode) (interface{}, error) {
+    if err := interp.ctx.Err(); err != nil {
+        return nil, fmt.Errorf("interpreter: evaluation stopped: %w", err)
+    }
+    interp.steps++
+    if max := interp.Limits.MaxSteps; max > 0 && interp.steps > max {
+        return nil, &LimitError{Limit: "step", Max: max}
+    }
+    interp.depth++
+    defer func() { interp.depth-- }()
+    if max := interp.Limits.MaxDepth; max > 0 && interp.depth > max {
+        return nil, &LimitError{Limit: "depth", Max: max}
+    }
+    if interp.trace == nil {
+        return node.Accept(interp)
+    }
+    interp.trace.enter(node)
+    value, err := node.Accept(interp)
+    if err == nil {
+        interp.trace.reduce(node, value)
+    }
+    return value, err
+}
+
+// Visit BinaryOperation: recursively evaluates both children and applies the operator to the results. Which
+// operators are allowed depends on the types of the operands (see operations.go). 
 func (interp *Interpreter) VisitBinaryOperation(node *ast.BinaryOperation) (interface{}, error) {
-    leftResult, err := node.LeftChild.Accept(interp) // recursively evaluate left child 
+    leftResult, err := interp.visit(node.LeftChild) // recursively evaluate left child 
     if err != nil {
-        return 0, err
+        return nil, err
     }
-    leftValue, ok := leftResult.(int) // type assertion on left result value
-    if !ok {
-        return 0, 
-        fmt.Errorf("interpreter.VisitBinaryOperation(): leftResult evaluation returned non-integer value")
+    if percent, ok := node.RightChild.(*ast.UnaryOperation); ok && percent.Postfix &&
+        percent.Operator.TokenType == PERCENT && (node.Operator.TokenType == PLUS || node.Operator.TokenType == MINUS) {
+        return interp.percentOperation(node.Operator, leftResult, percent)
     }
-    rightResult, err := node.RightChild.Accept(interp) // recursively evaluate right child 
+    rightResult, err := interp.visit(node.RightChild) // recursively evaluate right child 
     if err != nil {
         return nil, err
     }
-    rightValue, ok := rightResult.(int) // type assertion on right result value 
-    if !ok {
-        if errorNode, isErrorNode := rightResult.(*ast.ErrorNode); isErrorNode {
-            return 0, fmt.Errorf("interpreter encountered an error: %s", errorNode.ErrorType)
+    _, leftMatrix := leftResult.(*Matrix)
+    _, rightMatrix := rightResult.(*Matrix)
+    if leftMatrix || rightMatrix {
+        return interp.matrixOperation(node.Operator, leftResult, rightResult)
+    }
+    _, leftList := leftResult.(*List)
+    _, rightList := rightResult.(*List)
+    if leftList || rightList {
+        return interp.listOperation(node.Operator, leftResult, rightResult)
+    }
+    _, leftQuantity := leftResult.(*Quantity)
+    _, rightQuantity := rightResult.(*Quantity)
+    if leftQuantity || rightQuantity {
+        return interp.quantityOperation(node.Operator, leftResult, rightResult)
+    }
+    return interp.scalarOperation(node.Operator, leftResult, rightResult)
+}
+
+// scalarOperation applies a binary operator to two evaluated operands that are not matrices. On numbers .* is
+// the same as *. 
+func (interp *Interpreter) scalarOperation(operator *token.Token, leftResult, rightResult interface{}) (interface{}, error) {
+    if operator.TokenType == DOTMUL {
+        operator = retyped(operator, MUL)
+    }
+    if interp.complexOperands(operator, leftResult, rightResult) {
+        return complexOperation(operator, leftResult, rightResult)
+    }
+    switch leftValue := leftResult.(type) {
+    case int:
+        switch rightValue := rightResult.(type) {
+        case int:
+            return interp.integerOperation(operator, leftValue, rightValue)
+        case float64:
+            return floatOperation(operator, float64(leftValue), rightValue)
+        }
+    case float64:
+        switch rightValue := rightResult.(type) {
+        case int:
+            return floatOperation(operator, leftValue, float64(rightValue))
+        case float64:
+            return floatOperation(operator, leftValue, rightValue)
+        }
+    case bool:
+        if rightValue, ok := rightResult.(bool); ok {
+            return booleanOperation(operator, leftValue, rightValue)
         }
-       return nil, 
-       fmt.Errorf("interpreter.VisitBinaryOperation(): rightResult returned a non-integer value: %v",rightResult)
     }
+    return nil, typeError(operator, leftResult, rightResult)
+}
 
-    // perform operation corresponding to BinaryNodeOperation operator type 
+// Visit LogicalOperation: evaluates the left child and only evaluates the right child if the result is not
+// already decided (false && ..., true || ...). Both operands must be booleans. 
+func (interp *Interpreter) VisitLogicalOperation(node *ast.LogicalOperation) (interface{}, error) {
+    leftResult, err := interp.visit(node.LeftChild)
+    if err != nil {
+        return nil, err
+    }
+    leftValue, ok := leftResult.(bool)
+    if !ok {
+        return nil, typeError(node.Operator, leftResult)
+    }
     switch node.Operator.TokenType {
-    case PLUS:
-        return leftValue + rightValue, nil
-    case MINUS:
-        return leftValue - rightValue, nil
-    case MUL:
-        return leftValue * rightValue, nil
-    case DIV:
-        if rightValue == 0 {
-            return nil, fmt.Errorf("interpreter.VisitBinaryOperatrion(): division by zero") // div by 0 check
-        }
-        return leftValue / rightValue, nil
+    case AND:
+        if !leftValue {
+            return false, nil
+        }
+    case OR:
+        if leftValue {
+            return true, nil
+        }
     default:
-        return nil, fmt.Errorf("interpreter.VisitBinaryOperatrion(): default case reached")
+        return nil, fmt.Errorf("interpreter.VisitLogicalOperation(): default case reached")
     }
+    rightResult, err := interp.visit(node.RightChild)
+    if err != nil {
+        return nil, err
+    }
+    rightValue, ok := rightResult.(bool)
+    if !ok {
+        return nil, typeError(node.Operator, leftResult, rightResult)
+    }
+    return rightValue, nil
 }
 
-// Visit NumberLiteral: return integer value of the node
+// Visit NumberLiteral: return integer value of the node, truncated to the word size in programmer mode. In
+// the default mode a literal out of range is an error whatever the overflow mode: it is a typo, not the
+// result of an operation that could wrap or saturate. Literals are never negative, so a negative value is a
+// literal beyond the int64 range. 
 func (interp *Interpreter) VisitNumberLiteral(nl *ast.NumberLiteral) (interface{}, error) {
+    if interp.Word != nil {
+        return interp.Word.Normalize(uint64(int64(nl.Value))), nil
+    }
+    if nl.Value < 0 || nl.Value > MaxInt {
+        return nil, &OverflowError{Operation: "literal " + nl.String(), Position: nl.Token.Position}
+    }
     return nl.Value, nil
 }
 
+// Visit FloatLiteral: return the float64 value of the node 
+func (interp *Interpreter) VisitFloatLiteral(fl *ast.FloatLiteral) (interface{}, error) {
+    return fl.Value, nil
+}
+
+// Visit Variable: return the value of the constant with the variable's name (see constants.go), or the value
+// the variable was given in Variables
+func (interp *Interpreter) VisitVariable(va *ast.Variable) (interface{}, error) {
+    if constant, ok := constants[va.Name]; ok {
+        return constant.Value, nil
+    }
+    value, ok := interp.Variables[va.Name]
+    if !ok {
+        if va.Name == "ans" || va.Name == "_" {
+            // the last result, unless a variable of that name was assigned
+            if len(interp.History) == 0 {
+                return nil, fmt.Errorf("interpreter: no previous result for %s at column %d", va.Name, va.Token.Position + 1)
+            }
+            return interp.History[len(interp.History) - 1], nil
+        }
+        if va.Name == "i" {
+            return complex(0, 1), nil // the imaginary unit, unless a variable i was assigned
+        }
+        return nil, fmt.Errorf("interpreter: undefined variable: %s at column %d", va.Name, va.Token.Position + 1)
+    }
+    return value, nil
+}
+
+// Visit FunctionDefinition: make the function available to later calls, replacing a function of the same
+// name. A definition has no value. 
+func (interp *Interpreter) VisitFunctionDefinition(node *ast.FunctionDefinition) (interface{}, error) {
+    if _, ok := constants[node.Name]; ok {
+        return nil, fmt.Errorf("interpreter: cannot define function %s, it is a constant at column %d", node.Name,
+            node.Token.Position + 1)
+    }
+    if IsBuiltin(node.Name) {
+        return nil, fmt.Errorf("interpreter: cannot redefine built-in function %s at column %d", node.Name,
+            node.Token.Position + 1)
+    }
+    for _, parameter := range node.Parameters {
+        if err := readOnly(parameter); err != nil {
+            return nil, err
+        }
+    }
+    interp.Functions[node.Name] = node
+    return nil, nil
+}
+
+// Visit Call: evaluate the arguments, then the body of the function with its parameters set to them, or the
+// built-in function of that name (see builtins.go). The parameters are variables while the body is 
+// evaluated, variables of the same name get their values back afterwards. 
+func (interp *Interpreter) VisitCall(node *ast.Call) (interface{}, error) {
+    function, ok := interp.Functions[node.Name]
+    if !ok && !IsBuiltin(node.Name) {
+        return nil, fmt.Errorf("interpreter: undefined function: %s at column %d", node.Name, node.Token.Position + 1)
+    }
+    if commands[node.Name] {
+        return nil, fmt.Errorf("interpreter: %s is only available as a top-level REPL command, on a line of its own "+
+            "at column %d", node.Name, node.Token.Position + 1)
+    }
+    if ok && len(node.Arguments) != len(function.Parameters) {
+        return nil, fmt.Errorf("interpreter: %s takes %d arguments, not %d at column %d", node.Name,
+            len(function.Parameters), len(node.Arguments), node.Token.Position + 1)
+    }
+    arguments := make([]interface{}, len(node.Arguments))
+    for i, argument := range node.Arguments {
+        value, err := interp.visit(argument)
+        if err != nil {
+            return nil, err
+        }
+        if value == nil {
+            return nil, fmt.Errorf("interpreter: argument %d of %s has no value at column %d", i + 1, node.Name,
+                node.Token.Position + 1)
+        }
+        arguments[i] = value
+    }
+    if _, matrix := matrixBuiltins[node.Name]; matrix && !ok {
+        return interp.callMatrixBuiltin(node, arguments)
+    }
+    if _, aggregate := listBuiltins[node.Name]; aggregate && !ok {
+        return interp.callListBuiltin(node, arguments)
+    }
+    if _, statistic := statisticsBuiltins[node.Name]; statistic && !ok {
+        return interp.callStatisticsBuiltin(node, arguments)
+    }
+    if _, parts := complexBuiltins[node.Name]; parts && !ok {
+        return interp.callComplexBuiltin(node, arguments)
+    }
+    if !ok {
+        return interp.callBuiltin(node, arguments)
+    }
+    interp.calls++
+    defer func() { interp.calls-- }()
+    if max := interp.Limits.MaxRecursion; max > 0 && interp.calls > max {
+        return nil, &LimitError{Limit: "recursion", Max: max}
+    }
+    saved := make(map[string]interface{})
+    for i, parameter := range function.Parameters {
+        if value, ok := interp.Variables[parameter.Name]; ok {
+            saved[parameter.Name] = value
+        }
+        interp.Variables[parameter.Name] = arguments[i]
+    }
+    defer func() {
+        for _, parameter := range function.Parameters {
+            if value, ok := saved[parameter.Name]; ok {
+                interp.Variables[parameter.Name] = value
+            } else {
+                delete(interp.Variables, parameter.Name)
+            }
+        }
+    }()
+    return interp.visit(function.Body)
+}
+
+// Visit Equation: an equation can only be solved, see calculus.Solve 
+func (interp *Interpreter) VisitEquation(node *ast.Equation) (interface{}, error) {
+    return nil, fmt.Errorf("interpreter: an equation has no value, it can only be solved at column %d",
+        node.Token.Position + 1)
+}
+
+// Visit HistoryReference: return the earlier result with the node's number, counting from 1 
+func (interp *Interpreter) VisitHistoryReference(hr *ast.HistoryReference) (interface{}, error) {
+    if hr.Index < 1 || hr.Index > len(interp.History) {
+        return nil, fmt.Errorf("interpreter: no result $%d at column %d (%d results so far)", hr.Index,
+            hr.Token.Position + 1, len(interp.History))
+    }
+    return interp.History[hr.Index - 1], nil
+}
+
+// Visit Conditional: evaluates the condition, which must be a boolean, and then only the selected branch
+func (interp *Interpreter) VisitConditional(node *ast.Conditional) (interface{}, error) {
+    conditionResult, err := interp.visit(node.Condition)
+    if err != nil {
+        return nil, err
+    }
+    condition, ok := conditionResult.(bool)
+    if !ok {
+        return nil, fmt.Errorf("interpreter: type error: condition must be bool, not %s at column %d",
+            typeName(conditionResult), node.Token.Position + 1)
+    }
+    if condition {
+        return interp.visit(node.Consequent)
+    }
+    return interp.visit(node.Alternative)
+}
+
+// Visit Block: evaluates the statements in order and returns the value of the last one
+func (interp *Interpreter) VisitBlock(node *ast.Block) (interface{}, error) {
+    if len(node.Statements) == 0 {
+        return nil, fmt.Errorf("interpreter: empty block has no value at column %d", node.Token.Position + 1)
+    }
+    return interp.statements(node)
+}
+
+// statements evaluates the statements of a block in order and returns the value of the last one, or nil
+// if the block is empty 
+func (interp *Interpreter) statements(node *ast.Block) (interface{}, error) {
+    var result interface{}
+    for _, statement := range node.Statements {
+        var err error
+        result, err = interp.visit(statement)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return result, nil
+}
+
+// Visit Assignment: evaluates the value and stores it in Variables, the value is also the result
+func (interp *Interpreter) VisitAssignment(node *ast.Assignment) (interface{}, error) {
+    if err := readOnly(node.Variable); err != nil {
+        return nil, err
+    }
+    value, err := interp.visit(node.Value)
+    if err != nil {
+        return nil, err
+    }
+    if value == nil {
+        return nil, fmt.Errorf("interpreter: statement has no value to assign to %s at column %d",
+            node.Variable.Name, node.Token.Position + 1)
+    }
+    interp.Variables[node.Variable.Name] = value
+    return value, nil
+}
+
+// iterate counts one loop iteration against the MaxIterations limit 
+func (interp *Interpreter) iterate() error {
+    interp.iterations++
+    if max := interp.Limits.MaxIterations; max > 0 && interp.iterations > max {
+        return &LimitError{Limit: "iteration", Max: max}
+    }
+    return nil
+}
+
+// Visit While: evaluates the body for as long as the condition, which must be a boolean, is true. The 
+// result is the value of the last iteration of the body that ran to completion, or nil if none did. 
+func (interp *Interpreter) VisitWhile(node *ast.While) (interface{}, error) {
+    var result interface{}
+    for {
+        conditionResult, err := interp.visit(node.Condition)
+        if err != nil {
+            return nil, err
+        }
+        condition, ok := conditionResult.(bool)
+        if !ok {
+            return nil, fmt.Errorf("interpreter: type error: condition must be bool, not %s at column %d",
+                typeName(conditionResult), node.Token.Position + 1)
+        }
+        if !condition {
+            return result, nil
+        }
+        if err := interp.iterate(); err != nil {
+            return nil, err
+        }
+        value, err := interp.statements(node.Body)
+        if err == errBreak {
+            return result, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        result = value
+    }
+}
+
+// Visit For: evaluates the bounds once, which must be integers, then evaluates the body with the variable
+// set to each integer from start to end inclusive. The result is the same as for a while loop. The variable
+// only exists in the loop: a variable of the same name gets its value back afterwards, so for i in ... does
+// not hide the imaginary unit. 
+func (interp *Interpreter) VisitFor(node *ast.For) (interface{}, error) {
+    if err := readOnly(node.Variable); err != nil {
+        return nil, err
+    }
+    bounds := make([]int, 2)
+    for i, bound := range []ast.ASTNode{node.Start, node.End} {
+        boundResult, err := interp.visit(bound)
+        if err != nil {
+            return nil, err
+        }
+        value, ok := boundResult.(int)
+        if !ok {
+            return nil, fmt.Errorf("interpreter: type error: range bounds must be int, not %s at column %d",
+                typeName(boundResult), node.Token.Position + 1)
+        }
+        bounds[i] = value
+    }
+    name := node.Variable.Name
+    saved, ok := interp.Variables[name]
+    defer func() {
+        if ok {
+            interp.Variables[name] = saved
+        } else {
+            delete(interp.Variables, name)
+        }
+    }()
+    var result interface{}
+    for i := bounds[0]; i <= bounds[1]; i++ {
+        if err := interp.iterate(); err != nil {
+            return nil, err
+        }
+        interp.Variables[name] = i
+        value, err := interp.statements(node.Body)
+        if err == errBreak {
+            return result, nil
+        }
+        if err != nil {
+            return nil, err
+        }
+        result = value
+    }
+    return result, nil
+}
+
+// Visit Break: returns errBreak, which the innermost loop stops on 
+func (interp *Interpreter) VisitBreak(node *ast.Break) (interface{}, error) {
+    return nil, errBreak
+}
+
+// Visit BooleanLiteral: return boolean value of the node
+func (interp *Interpreter) VisitBooleanLiteral(bl *ast.BooleanLiteral) (interface{}, error) {
+    return bl.Value, nil
+}
+
 
 // Visit UnaryOperation: recursively evaluates its child node and, if the operator type was negative 
-// then it returns the result multiplied by -1. Otherwise it returns the result unmodified. 
+// then it returns the result multiplied by -1, if it was NOT then it returns the negated boolean, and if it
+// was TILDE then it returns the bitwise complement. The postfix operators return the factorial (5!) or the
+// value divided by 100 (10%, a percentage that is not added to or subtracted from something).
+// Otherwise it returns the result unmodified. 
 func (interp *Interpreter) VisitUnaryOperation(node *ast.UnaryOperation) (interface{}, error) {
     
-    exprResult, err := node.Expr.Accept(interp) // recursively evaluate child node 
+    exprResult, err := interp.visit(node.Expr) // recursively evaluate child node 
     if err != nil {
-        return 0, err
-    }
-    exprValue, ok := exprResult.(int) // type assertion 
-    if !ok {
-        return 0,
-        fmt.Errorf("interpreter.UnaryOperation(): leftResult evaluation returned non-integer value")
+        return nil, err
     }
     switch node.Operator.TokenType {
-    case PLUS:
-        return exprValue, nil
-    case MINUS:
-        return -exprValue, nil // multiply result by -1 and return it 
-    default:
-        return 0, fmt.Errorf("VisitUnaryOperation(): default case reached")
+    case FACTORIAL:
+        return interp.factorial(node.Operator, exprResult)
+    case PERCENT:
+        switch exprValue := exprResult.(type) {
+        case int:
+            return float64(exprValue) / 100, nil
+        case float64:
+            return exprValue / 100, nil
+        }
+        return nil, typeError(node.Operator, exprResult)
+    }
+    switch exprValue := exprResult.(type) {
+    case float64:
+        switch node.Operator.TokenType {
+        case PLUS:
+            return exprValue, nil
+        case MINUS:
+            return -exprValue, nil
+        }
+    case int:
+        switch node.Operator.TokenType {
+        case PLUS:
+            return exprValue, nil
+        case MINUS:
+            if interp.Word != nil {
+                return interp.Word.Normalize(-uint64(int64(exprValue))), nil
+            }
+            // multiply result by -1 and return it, -MinInt is out of range 
+            return interp.checkRange(-int64(exprValue), node.Operator, func() string {
+                return fmt.Sprintf("-(%d)", exprValue)
+            })
+        case TILDE:
+            if interp.Word != nil {
+                return interp.Word.Normalize(^uint64(int64(exprValue))), nil
+            }
+            return ^exprValue, nil
+        }
+    case bool:
+        if node.Operator.TokenType == NOT {
+            return !exprValue, nil
+        }
+    case *Matrix:
+        switch node.Operator.TokenType {
+        case PLUS:
+            return exprValue, nil
+        case MINUS:
+            return interp.matrixOperation(retyped(node.Operator, MUL), -1, exprValue)
+        }
+    case *List:
+        switch node.Operator.TokenType {
+        case PLUS:
+            return exprValue, nil
+        case MINUS:
+            return interp.listOperation(retyped(node.Operator, MUL), -1, exprValue)
+        }
+    case complex128:
+        switch node.Operator.TokenType {
+        case PLUS:
+            return exprValue, nil
+        case MINUS:
+            return -exprValue, nil
+        }
+    case *Quantity:
+        switch node.Operator.TokenType {
+        case PLUS:
+            return exprValue, nil
+        case MINUS:
+            return &Quantity{Value: -exprValue.Value, Unit: exprValue.Unit}, nil
+        }
     }
+    return nil, typeError(node.Operator, exprResult)
 }
 
 // Returns the error message in the node. Here for consistency 
@@ -109,23 +704,89 @@ func (interp *Interpreter) VisitErrorNode(en *ast.ErrorNode) (interface{}, error
 
 // Interpret tree: initializes visitor pattern with the root node. 
 func (interp *Interpreter) Interpret() (int, error) {
+    return interp.InterpretContext(context.Background())
+}
+
+// InterpretContext is Interpret with cancellation: evaluation stops with an error wrapping ctx.Err() as soon
+// as ctx is done. The Limits on the interpreter are enforced in both entry points. 
+func (interp *Interpreter) InterpretContext(ctx context.Context) (int, error) {
+    result, err := interp.EvaluateContext(ctx)
+    if err != nil {
+        return 0, err
+    }
+    finalResult, ok := result.(int) // type assertion
+    if !ok {
+        return 0, fmt.Errorf("interpreter.Interpret(): final result is a non-integer value: %v", result)
+    }
+    return finalResult, nil
+}
+
+// EvaluateNode evaluates a tree that did not come from the interpreter's parser (one built by a program, or
+// part of a parsed input) with the interpreter's settings, variables and functions. The budgets apply to it
+// as they do to a whole input. 
+func (interp *Interpreter) EvaluateNode(node ast.ASTNode) (interface{}, error) {
+    interp.StartBudgets()
+    return interp.ContinueNode(node)
+}
+
+// StartBudgets starts the step, depth and iteration budgets over, as every entry point does. A program that
+// evaluates many trees as one computation (calculus.Solve evaluates the equation at thousands of points)
+// calls it once and evaluates the trees with ContinueNode, so the Limits bound the whole computation.
+func (interp *Interpreter) StartBudgets() {
+    interp.steps = 0
+    interp.depth = 0
+    interp.iterations = 0
+    interp.calls = 0
+}
+
+// ContinueNode is EvaluateNode on what is left of the budgets, see StartBudgets
+func (interp *Interpreter) ContinueNode(node ast.ASTNode) (interface{}, error) {
+    return interp.visit(node)
+}
+
+// Evaluate is Interpret for expressions whose result is not necessarily an integer: the result is an int,
+// float64, bool, complex128, *List, *Matrix or *Quantity, or nil for a statement without a value. 
+func (interp *Interpreter) Evaluate() (interface{}, error) {
+    return interp.EvaluateContext(context.Background())
+}
+
+// EvaluateContext is Evaluate with cancellation, see InterpretContext. 
+func (interp *Interpreter) EvaluateContext(ctx context.Context) (interface{}, error) {
+    interp.ctx = ctx
+    interp.steps = 0
+    interp.depth = 0
+    interp.iterations = 0
+    interp.calls = 0
     root, err := interp.Parser.Parse()
     if err != nil {
-        return 0, err // error returned from parser.Parse()
+        return nil, err // error returned from parser.Parse()
+    }
+    if block, ok := root.(*ast.Block); root == nil || ok && len(block.Statements) == 0 {
+        return nil, fmt.Errorf("interpreter.Interpret(): parser.Parse(): parsed an empty expression")
+    }
+    if interp.trace != nil {
+        interp.trace.start(root)
     }
-    if root == nil {
-        return 0, fmt.Errorf("interpreter.Interpret(): parser.Parse(): parsed an empty expression")
+    // the root Block only wraps the statements of the input, it doesn't count towards the budgets 
+    if max := interp.Limits.MaxNodes; max > 0 {
+        count := -1
+        ast.Inspect(root, func(ast.ASTNode) bool {
+            count++
+            return true
+        })
+        if count > max {
+            return nil, &LimitError{Limit: "node", Max: max}
+        }
     }
     result, err := root.Accept(interp)
     if err != nil {
-        return 0, err // error returned somewhere in interpretation
-    }
-    finalResult, ok := result.(int) // type assertion
-    if !ok {
-        if errorNode, isErrorNode := root.(*ast.ErrorNode); isErrorNode { // interpreter returned error node
-            return 0, fmt.Errorf("interpreter encountered an error: %s", errorNode.ErrorType)
+        if err == errBreak {
+            return nil, fmt.Errorf("interpreter: %w", err) // the parser should never let this happen
         }
-        return 0, fmt.Errorf("interpreter.Interpret(): final result is a non-integer value")
+        return nil, err // error returned somewhere in interpretation
     }
-    return finalResult, nil
+    if errorNode, isErrorNode := result.(*ast.ErrorNode); isErrorNode { // interpreter returned error node
+        return nil, fmt.Errorf("interpreter encountered an error: %s", errorNode.ErrorType)
+    }
+    return result, nil
 }
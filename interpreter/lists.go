@@ -0,0 +1,269 @@
+package interpreter
+
+/*
+
+List values: [1, 2, 3], or a range 1..10 of the integers from 1 to 10. The elements are ints and floats.
+Elements are indexed from 1 (xs[1]), negative indices count from the end (xs[-1] is the last one), and
+m[i] is row i of a matrix and m[i, j] an element. In arithmetic a list is a row vector, so [1, 2] * 2 is
+[2, 4] and [1, 2] + 1 is a type error; the result is a list again unless a matrix was involved. The
+aggregate functions take a list, a matrix (all of its elements) or numbers: sum(1..10), max(3, 7).
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/token"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// List is a list value, of ints and floats
+type List struct {
+    Elements []interface{}
+}
+
+// Format writes the list as a literal, [1, 2, 3], with each element written by element
+func (l *List) Format(element func(value interface{}) string) string {
+    elements := make([]string, len(l.Elements))
+    for i, value := range l.Elements {
+        elements[i] = element(value)
+    }
+    if len(elements) == 1 {
+        return "[" + elements[0] + ",]" // [3] would be a grouped 3
+    }
+    return "[" + strings.Join(elements, ", ") + "]"
+}
+
+func (l *List) String() string {
+    return l.Format(func(value interface{}) string { return fmt.Sprintf("%v", value) })
+}
+
+// rowVector returns a list as a matrix of one row, and any other value as it is
+func rowVector(value interface{}) interface{} {
+    if l, ok := value.(*List); ok {
+        return &Matrix{Rows: 1, Columns: len(l.Elements), Elements: l.Elements}
+    }
+    return value
+}
+
+// Visit ListLiteral: evaluate the elements, which must be numbers
+func (interp *Interpreter) VisitListLiteral(node *ast.ListLiteral) (interface{}, error) {
+    l := &List{Elements: make([]interface{}, len(node.Elements))}
+    for i, element := range node.Elements {
+        value, err := interp.visit(element)
+        if err != nil {
+            return nil, err
+        }
+        if !isNumber(value) {
+            return nil, fmt.Errorf("interpreter: type error: list element %d is %s, not a number at column %d", i + 1,
+                typeName(value), node.Token.Position + 1)
+        }
+        l.Elements[i] = value
+    }
+    return l, nil
+}
+
+// Visit Range: the list of the integers from start to end inclusive, empty if end is less than start. Each
+// element counts as an iteration against the interpreter's limit, so 1..1e9 is not built by accident.
+func (interp *Interpreter) VisitRange(node *ast.Range) (interface{}, error) {
+    bounds := make([]int, 2)
+    for i, bound := range []ast.ASTNode{node.Start, node.End} {
+        boundResult, err := interp.visit(bound)
+        if err != nil {
+            return nil, err
+        }
+        value, ok := boundResult.(int)
+        if !ok {
+            return nil, fmt.Errorf("interpreter: type error: range bounds must be int, not %s at column %d",
+                typeName(boundResult), node.Token.Position + 1)
+        }
+        bounds[i] = value
+    }
+    l := &List{Elements: make([]interface{}, 0)}
+    for i := bounds[0]; i <= bounds[1]; i++ {
+        if err := interp.iterate(); err != nil {
+            return nil, err
+        }
+        l.Elements = append(l.Elements, i)
+    }
+    return l, nil
+}
+
+// Visit Index: an element of a list, or a row or element of a matrix
+func (interp *Interpreter) VisitIndex(node *ast.Index) (interface{}, error) {
+    value, err := interp.visit(node.Expr)
+    if err != nil {
+        return nil, err
+    }
+    indices := make([]int, len(node.Indices))
+    for i, index := range node.Indices {
+        indexResult, err := interp.visit(index)
+        if err != nil {
+            return nil, err
+        }
+        var ok bool
+        if indices[i], ok = indexResult.(int); !ok {
+            return nil, fmt.Errorf("interpreter: type error: index must be int, not %s at column %d",
+                typeName(indexResult), node.Token.Position + 1)
+        }
+    }
+    switch v := value.(type) {
+    case *List:
+        if len(indices) == 1 {
+            i, err := position(node.Token, indices[0], len(v.Elements), "a list of %d elements")
+            if err != nil {
+                return nil, err
+            }
+            return v.Elements[i], nil
+        }
+    case *Matrix:
+        row, err := position(node.Token, indices[0], v.Rows, "a matrix of %d rows")
+        if err != nil {
+            return nil, err
+        }
+        switch len(indices) {
+        case 1:
+            elements := make([]interface{}, v.Columns)
+            copy(elements, v.Elements[row * v.Columns:])
+            return &List{Elements: elements}, nil
+        case 2:
+            column, err := position(node.Token, indices[1], v.Columns, "a matrix of %d columns")
+            if err != nil {
+                return nil, err
+            }
+            return v.At(row, column), nil
+        }
+    default:
+        return nil, fmt.Errorf("interpreter: type error: cannot index %s at column %d", typeName(value),
+            node.Token.Position + 1)
+    }
+    return nil, fmt.Errorf("interpreter: too many indices for a %s: %d at column %d", typeName(value), len(indices),
+        node.Token.Position + 1)
+}
+
+// listOperation applies an operator where one operand, or both, is a list and neither a matrix. The lists
+// are row vectors, and a result of one row is a list again.
+func (interp *Interpreter) listOperation(operator *token.Token, leftResult, rightResult interface{}) (interface{}, error) {
+    result, err := interp.matrixOperation(operator, leftResult, rightResult)
+    if err != nil {
+        return nil, err
+    }
+    if m, ok := result.(*Matrix); ok && m.Rows == 1 {
+        return &List{Elements: m.Elements}, nil
+    }
+    return result, nil
+}
+
+// position converts an index counting from 1, or from -1 at the end, into one counting from 0
+func position(bracket *token.Token, index, length int, what string) (int, error) {
+    i := index - 1
+    if index < 0 {
+        i = length + index
+    }
+    if index == 0 || i < 0 || i >= length {
+        return 0, fmt.Errorf("interpreter: index %d is out of range for %s at column %d", index,
+            fmt.Sprintf(what, length), bracket.Position + 1)
+    }
+    return i, nil
+}
+
+// the aggregate functions, of the numbers they are given and the token of the call
+var listBuiltins = map[string]func(interp *Interpreter, name *token.Token, numbers []interface{}) (interface{}, error){
+    "sum": func(interp *Interpreter, name *token.Token, numbers []interface{}) (interface{}, error) {
+        return interp.sum(name, numbers)
+    },
+    "avg": func(interp *Interpreter, name *token.Token, numbers []interface{}) (interface{}, error) {
+        if len(numbers) == 0 {
+            return nil, emptyError(name)
+        }
+        return floatSum(numbers) / float64(len(numbers)), nil
+    },
+    "min": func(interp *Interpreter, name *token.Token, numbers []interface{}) (interface{}, error) {
+        return extreme(name, numbers, func(a, b float64) bool { return a < b })
+    },
+    "max": func(interp *Interpreter, name *token.Token, numbers []interface{}) (interface{}, error) {
+        return extreme(name, numbers, func(a, b float64) bool { return a > b })
+    },
+    "count": func(interp *Interpreter, name *token.Token, numbers []interface{}) (interface{}, error) {
+        return len(numbers), nil
+    },
+    "sort": func(interp *Interpreter, name *token.Token, numbers []interface{}) (interface{}, error) {
+        sorted := make([]interface{}, len(numbers))
+        copy(sorted, numbers)
+        sort.SliceStable(sorted, func(i, j int) bool { return float(sorted[i]) < float(sorted[j]) })
+        return &List{Elements: sorted}, nil
+    },
+}
+
+// callListBuiltin applies an aggregate function to its evaluated arguments: one list or matrix, or numbers
+func (interp *Interpreter) callListBuiltin(node *ast.Call, arguments []interface{}) (interface{}, error) {
+    numbers := arguments
+    if len(arguments) == 1 {
+        switch v := arguments[0].(type) {
+        case *List:
+            numbers = v.Elements
+        case *Matrix:
+            numbers = v.Elements
+        }
+    }
+    for _, number := range numbers {
+        if !isNumber(number) {
+            return nil, fmt.Errorf("interpreter: type error: cannot apply %s to %s at column %d", node.Name,
+                typeName(number), node.Token.Position + 1)
+        }
+    }
+    return listBuiltins[node.Name](interp, node.Token, numbers)
+}
+
+// float returns a number as a float
+func float(number interface{}) float64 {
+    if i, ok := number.(int); ok {
+        return float64(i)
+    }
+    return number.(float64)
+}
+
+// sum adds up numbers with +, so ints stay ints and overflow as usual
+func (interp *Interpreter) sum(name *token.Token, numbers []interface{}) (interface{}, error) {
+    var total interface{} = 0
+    plus := retyped(name, PLUS)
+    for _, number := range numbers {
+        var err error
+        if total, err = interp.scalarOperation(plus, total, number); err != nil {
+            return nil, err
+        }
+    }
+    return total, nil
+}
+
+// floatSum adds up numbers as floats, with Kahan summation so the rounding errors do not add up
+func floatSum(numbers []interface{}) float64 {
+    total, compensation := 0.0, 0.0
+    for _, number := range numbers {
+        y := float(number) - compensation
+        t := total + y
+        compensation = (t - total) - y
+        total = t
+    }
+    return total
+}
+
+// extreme returns the number that is before all others by before, as it is (an int stays an int)
+func extreme(name *token.Token, numbers []interface{}, before func(a, b float64) bool) (interface{}, error) {
+    if len(numbers) == 0 {
+        return nil, emptyError(name)
+    }
+    result := numbers[0]
+    for _, number := range numbers[1:] {
+        if before(float(number), float(result)) {
+            result = number
+        }
+    }
+    return result, nil
+}
+
+// emptyError reports an aggregate that has no value for no numbers
+func emptyError(name *token.Token) error {
+    return fmt.Errorf("interpreter: %s of no numbers at column %d", name.Literal, name.Position + 1)
+}
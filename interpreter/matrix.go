@@ -0,0 +1,394 @@
+package interpreter
+
+/*
+
+Matrix values and the linear algebra on them. A matrix holds ints and floats row by row, and the arithmetic
+on its elements is the arithmetic on numbers (see scalarOperation), so ints stay ints and overflow as they
+would anywhere else. + and - work element by element, * is the matrix product and .* the element-wise one;
+a matrix can also be multiplied or divided by a number and raised to an integer power. The determinant and
+the inverse are computed exactly on big.Rat and converted back: ints where a matrix of ints gives a whole
+number, floats otherwise.
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/token"
+    "fmt"
+    "math"
+    "math/big"
+    "strings"
+)
+
+// Matrix is a matrix value, Elements holds its ints and floats row by row
+type Matrix struct {
+    Rows, Columns int
+    Elements []interface{}
+}
+
+// NewMatrix returns a matrix of the given size with every element 0
+func NewMatrix(rows, columns int) *Matrix {
+    m := &Matrix{Rows: rows, Columns: columns, Elements: make([]interface{}, rows * columns)}
+    for i := range m.Elements {
+        m.Elements[i] = 0
+    }
+    return m
+}
+
+// At returns the element in row i and column j, counting from 0
+func (m *Matrix) At(i, j int) interface{} {
+    return m.Elements[i * m.Columns + j]
+}
+
+// Set sets the element in row i and column j
+func (m *Matrix) Set(i, j int, value interface{}) {
+    m.Elements[i * m.Columns + j] = value
+}
+
+// Format writes the matrix as a literal, [1, 2; 3, 4], with each element written by element
+func (m *Matrix) Format(element func(value interface{}) string) string {
+    rows := make([]string, m.Rows)
+    for i := range rows {
+        elements := make([]string, m.Columns)
+        for j := range elements {
+            elements[j] = element(m.At(i, j))
+        }
+        rows[i] = strings.Join(elements, ", ")
+    }
+    return "[" + strings.Join(rows, "; ") + "]"
+}
+
+func (m *Matrix) String() string {
+    return m.Format(func(value interface{}) string { return fmt.Sprintf("%v", value) })
+}
+
+// size returns the size of the matrix as used in error messages: 2x3
+func (m *Matrix) size() string {
+    return fmt.Sprintf("%dx%d", m.Rows, m.Columns)
+}
+
+// Visit MatrixLiteral: evaluate the elements, which must be numbers
+func (interp *Interpreter) VisitMatrixLiteral(node *ast.MatrixLiteral) (interface{}, error) {
+    m := NewMatrix(len(node.Rows), len(node.Rows[0]))
+    for i, row := range node.Rows {
+        for j, element := range row {
+            value, err := interp.visit(element)
+            if err != nil {
+                return nil, err
+            }
+            switch value.(type) {
+            case int, float64:
+                m.Set(i, j, value)
+            default:
+                return nil, fmt.Errorf("interpreter: type error: matrix element %d,%d is %s, not a number at column %d",
+                    i + 1, j + 1, typeName(value), node.Token.Position + 1)
+            }
+        }
+    }
+    return m, nil
+}
+
+// retyped returns a copy of an operator token with another type, for the operations on elements that make up
+// an operation on matrices, so their errors point at the operator
+func retyped(operator *token.Token, tokenType string) *token.Token {
+    t := *operator
+    t.TokenType = tokenType
+    return &t
+}
+
+// dimensionError reports matrices whose sizes do not fit the operator
+func dimensionError(operator *token.Token, format string, a ...interface{}) error {
+    return fmt.Errorf("interpreter: dimension mismatch: %s at column %d", fmt.Sprintf(format, a...), operator.Position + 1)
+}
+
+// matrixOperation applies an operator where one operand, or both, is a matrix, and the other may be a list
+// (see lists.go)
+func (interp *Interpreter) matrixOperation(operator *token.Token, leftResult, rightResult interface{}) (interface{}, error) {
+    a, leftMatrix := rowVector(leftResult).(*Matrix)
+    b, rightMatrix := rowVector(rightResult).(*Matrix)
+    switch {
+    case leftMatrix && rightMatrix:
+        switch operator.TokenType {
+        case PLUS, MINUS, DOTMUL:
+            if a.Rows != b.Rows || a.Columns != b.Columns {
+                return nil, dimensionError(operator, "cannot apply %s to a %s and a %s matrix", symbols[operator.TokenType],
+                    a.size(), b.size())
+            }
+            return interp.elementwise(a, func(i int, x interface{}) (interface{}, error) {
+                return interp.scalarOperation(operator, x, b.Elements[i])
+            })
+        case MUL:
+            return interp.product(operator, a, b)
+        case EQ, NE:
+            equal := a.Rows == b.Rows && a.Columns == b.Columns
+            for i := 0; equal && i < len(a.Elements); i++ {
+                same, err := interp.scalarOperation(retyped(operator, EQ), a.Elements[i], b.Elements[i])
+                if err != nil {
+                    return nil, err
+                }
+                equal = same.(bool)
+            }
+            return equal == (operator.TokenType == EQ), nil
+        }
+    case leftMatrix && isNumber(rightResult):
+        switch operator.TokenType {
+        case MUL, DOTMUL, DIV:
+            return interp.elementwise(a, func(i int, x interface{}) (interface{}, error) {
+                return interp.scalarOperation(operator, x, rightResult)
+            })
+        case POWER:
+            if exponent, ok := rightResult.(int); ok {
+                return interp.matrixPower(operator, a, exponent)
+            }
+        }
+    case rightMatrix && isNumber(leftResult):
+        switch operator.TokenType {
+        case MUL, DOTMUL:
+            return interp.elementwise(b, func(i int, x interface{}) (interface{}, error) {
+                return interp.scalarOperation(operator, leftResult, x)
+            })
+        }
+    }
+    return nil, typeError(operator, leftResult, rightResult)
+}
+
+// isNumber reports whether a value is an int or a float
+func isNumber(value interface{}) bool {
+    switch value.(type) {
+    case int, float64:
+        return true
+    }
+    return false
+}
+
+// elementwise returns the matrix of f applied to each element of m and its index in Elements
+func (interp *Interpreter) elementwise(m *Matrix, f func(i int, x interface{}) (interface{}, error)) (*Matrix, error) {
+    result := NewMatrix(m.Rows, m.Columns)
+    for i, x := range m.Elements {
+        value, err := f(i, x)
+        if err != nil {
+            return nil, err
+        }
+        result.Elements[i] = value
+    }
+    return result, nil
+}
+
+// product returns the matrix product a * b
+func (interp *Interpreter) product(operator *token.Token, a, b *Matrix) (*Matrix, error) {
+    if a.Columns != b.Rows {
+        return nil, dimensionError(operator, "cannot multiply a %s by a %s matrix, %d columns but %d rows", a.size(),
+            b.size(), a.Columns, b.Rows)
+    }
+    plus := retyped(operator, PLUS)
+    result := NewMatrix(a.Rows, b.Columns)
+    for i := 0; i < a.Rows; i++ {
+        for j := 0; j < b.Columns; j++ {
+            var sum interface{}
+            for k := 0; k < a.Columns; k++ {
+                term, err := interp.scalarOperation(operator, a.At(i, k), b.At(k, j))
+                if err != nil {
+                    return nil, err
+                }
+                if k > 0 {
+                    if term, err = interp.scalarOperation(plus, sum, term); err != nil {
+                        return nil, err
+                    }
+                }
+                sum = term
+            }
+            result.Set(i, j, sum)
+        }
+    }
+    return result, nil
+}
+
+// matrixPower raises a square matrix to an integer power by repeated squaring, a negative power is a power
+// of the inverse
+func (interp *Interpreter) matrixPower(operator *token.Token, m *Matrix, exponent int) (*Matrix, error) {
+    if m.Rows != m.Columns {
+        return nil, dimensionError(operator, "cannot raise a %s matrix to a power, it is not square", m.size())
+    }
+    if exponent < 0 {
+        inverse, err := interp.inverse(operator, m)
+        if err != nil {
+            return nil, err
+        }
+        m, exponent = inverse, -exponent
+    }
+    mul := retyped(operator, MUL)
+    result := NewMatrix(m.Rows, m.Columns)
+    for i := 0; i < m.Rows; i++ {
+        result.Set(i, i, 1)
+    }
+    for ; exponent > 0; exponent >>= 1 {
+        var err error
+        if exponent & 1 == 1 {
+            if result, err = interp.product(mul, result, m); err != nil {
+                return nil, err
+            }
+        }
+        if exponent > 1 {
+            if m, err = interp.product(mul, m, m); err != nil {
+                return nil, err
+            }
+        }
+    }
+    return result, nil
+}
+
+// the built-in functions of a matrix
+var matrixBuiltins = map[string]func(interp *Interpreter, operator *token.Token, m *Matrix) (interface{}, error){
+    "transpose": func(interp *Interpreter, operator *token.Token, m *Matrix) (interface{}, error) {
+        result := NewMatrix(m.Columns, m.Rows)
+        for i := 0; i < m.Rows; i++ {
+            for j := 0; j < m.Columns; j++ {
+                result.Set(j, i, m.At(i, j))
+            }
+        }
+        return result, nil
+    },
+    "det": func(interp *Interpreter, operator *token.Token, m *Matrix) (interface{}, error) {
+        return interp.determinant(operator, m)
+    },
+    "inv": func(interp *Interpreter, operator *token.Token, m *Matrix) (interface{}, error) {
+        return interp.inverse(operator, m)
+    },
+}
+
+// callMatrixBuiltin applies a built-in function of a matrix to its evaluated arguments
+func (interp *Interpreter) callMatrixBuiltin(node *ast.Call, arguments []interface{}) (interface{}, error) {
+    if len(arguments) != 1 {
+        return nil, fmt.Errorf("interpreter: %s takes 1 argument, not %d at column %d", node.Name, len(arguments),
+            node.Token.Position + 1)
+    }
+    m, ok := rowVector(arguments[0]).(*Matrix)
+    if !ok {
+        return nil, fmt.Errorf("interpreter: type error: cannot apply %s to %s at column %d", node.Name,
+            typeName(arguments[0]), node.Token.Position + 1)
+    }
+    return matrixBuiltins[node.Name](interp, node.Token, m)
+}
+
+// rationals returns the rows of a square matrix as big.Rat, with room for extra columns, and whether any
+// element is a float
+func (interp *Interpreter) rationals(operator *token.Token, m *Matrix, extra int) ([][]*big.Rat, bool, error) {
+    if m.Rows != m.Columns {
+        return nil, false, dimensionError(operator, "%s needs a square matrix, not a %s one", operator.Literal, m.size())
+    }
+    floats := false
+    rows := make([][]*big.Rat, m.Rows)
+    for i := range rows {
+        rows[i] = make([]*big.Rat, m.Columns + extra)
+        for j := range rows[i] {
+            rows[i][j] = new(big.Rat)
+            if j >= m.Columns {
+                continue
+            }
+            switch x := m.At(i, j).(type) {
+            case int:
+                rows[i][j].SetInt64(int64(x))
+            case float64:
+                floats = true
+                if rows[i][j].SetFloat64(x) == nil {
+                    return nil, false, fmt.Errorf("interpreter: %s: element %d,%d is %v at column %d", operator.Literal,
+                        i + 1, j + 1, x, operator.Position + 1)
+                }
+            }
+        }
+    }
+    return rows, floats, nil
+}
+
+// eliminate brings the rows into reduced row echelon form by Gauss-Jordan elimination on their first n
+// columns and returns the determinant of those, which is 0 if they are singular
+func eliminate(rows [][]*big.Rat, n int) *big.Rat {
+    determinant := big.NewRat(1, 1)
+    for j := 0; j < n; j++ {
+        p := j
+        for p < n && rows[p][j].Sign() == 0 {
+            p++
+        }
+        if p == n {
+            return new(big.Rat)
+        }
+        if p != j {
+            rows[p], rows[j] = rows[j], rows[p]
+            determinant.Neg(determinant)
+        }
+        pivot := new(big.Rat).Set(rows[j][j])
+        determinant.Mul(determinant, pivot)
+        for k := range rows[j] {
+            rows[j][k].Quo(rows[j][k], pivot)
+        }
+        for i := range rows {
+            if i == j || rows[i][j].Sign() == 0 {
+                continue
+            }
+            factor := new(big.Rat).Set(rows[i][j])
+            for k := range rows[i] {
+                rows[i][k].Sub(rows[i][k], new(big.Rat).Mul(factor, rows[j][k]))
+            }
+        }
+    }
+    return determinant
+}
+
+// number converts an exact result back to a value: an int if it is whole and no float was involved, brought
+// into range like any other int, or a float
+func (interp *Interpreter) number(operator *token.Token, r *big.Rat, floats bool) (interface{}, error) {
+    if !floats && r.IsInt() {
+        var whole int64
+        switch {
+        case r.Num().IsInt64():
+            whole = r.Num().Int64()
+        case interp.Word != nil || interp.Overflow == OverflowWrap:
+            // the low 64 bits, which the word or the wrap keeps the low bits of
+            whole = int64(new(big.Int).Mod(r.Num(), new(big.Int).Lsh(big.NewInt(1), 64)).Uint64())
+        case r.Sign() < 0:
+            whole = math.MinInt64
+        default:
+            whole = math.MaxInt64
+        }
+        if interp.Word != nil {
+            return interp.Word.Normalize(uint64(whole)), nil
+        }
+        return interp.checkRange(whole, operator, func() string { return operator.Literal + " = " + r.RatString() })
+    }
+    f, _ := r.Float64()
+    return f, nil
+}
+
+// determinant returns the determinant of a square matrix
+func (interp *Interpreter) determinant(operator *token.Token, m *Matrix) (interface{}, error) {
+    rows, floats, err := interp.rationals(operator, m, 0)
+    if err != nil {
+        return nil, err
+    }
+    return interp.number(operator, eliminate(rows, m.Rows), floats)
+}
+
+// inverse returns the inverse of a square matrix, a singular matrix has none
+func (interp *Interpreter) inverse(operator *token.Token, m *Matrix) (*Matrix, error) {
+    rows, floats, err := interp.rationals(operator, m, m.Columns)
+    if err != nil {
+        return nil, err
+    }
+    for i := range rows {
+        rows[i][m.Columns + i].SetInt64(1) // [m | I] becomes [I | m^-1]
+    }
+    if eliminate(rows, m.Rows).Sign() == 0 {
+        return nil, fmt.Errorf("interpreter: the matrix is singular, it has no inverse at column %d", operator.Position + 1)
+    }
+    result := NewMatrix(m.Rows, m.Columns)
+    for i := range rows {
+        for j := 0; j < m.Columns; j++ {
+            value, err := interp.number(operator, rows[i][m.Columns + j], floats)
+            if err != nil {
+                return nil, err
+            }
+            result.Set(i, j, value)
+        }
+    }
+    return result, nil
+}
@@ -0,0 +1,327 @@
+package interpreter
+
+/*
+
+The operations applied by VisitBinaryOperation once both operands are evaluated, one function per
+combination of operand types, plus the helpers used to keep integer results in range and report type errors. 
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/token"
+    "fmt"
+    "math"
+)
+
+// operator symbols used when reporting errors 
+var symbols = map[string]string{
+    PLUS:  "+",
+    MINUS: "-",
+    MUL:   "*",
+    DIV:   "/",
+    EQ:    "==",
+    NE:    "!=",
+    LT:    "<",
+    LE:    "<=",
+    GT:    ">",
+    GE:    ">=",
+    AND:   "&&",
+    OR:    "||",
+    NOT:   "!",
+    BITAND: "&",
+    BITOR: "|",
+    CARET: "^",
+    TILDE: "~",
+    SHL:   "<<",
+    SHR:   ">>",
+    POWER: "^",
+    PERCENT: "%",
+    FACTORIAL: "!",
+    DOTMUL: ".*",
+}
+
+// integerOperation applies an arithmetic, bitwise or comparison operator to two integers. In the default
+// mode the operands are within 32 bits, so the exact result of an arithmetic operator always fits in an int64
+// and can be range checked afterwards. With a word size the word does the arithmetic instead (see words.go).
+func (interp *Interpreter) integerOperation(operator *token.Token, leftValue, rightValue int) (interface{}, error) {
+    if operator.TokenType == POWER {
+        return interp.power(operator, leftValue, rightValue)
+    }
+    if interp.Word != nil {
+        return interp.Word.operation(operator, leftValue, rightValue)
+    }
+    left, right := int64(leftValue), int64(rightValue)
+    var result int64
+    switch operator.TokenType {
+    case PLUS:
+        result = left + right
+    case MINUS:
+        result = left - right
+    case MUL:
+        result = left * right
+    case DIV:
+        if rightValue == 0 {
+            return nil, ErrDivisionByZero // div by 0 check
+        }
+        result = left / right // MinInt / -1 is the only quotient that can overflow
+    case BITAND:
+        return leftValue & rightValue, nil // bitwise operations on 32-bit values stay within 32 bits
+    case BITOR:
+        return leftValue | rightValue, nil
+    case CARET:
+        return leftValue ^ rightValue, nil
+    case SHL, SHR:
+        if rightValue < 0 {
+            return nil, fmt.Errorf("interpreter: negative shift count %d at column %d", rightValue, operator.Position + 1)
+        }
+        if operator.TokenType == SHR {
+            return leftValue >> uint(rightValue), nil // arithmetic shift, never overflows
+        }
+        if right > 32 {
+            right = 32 // still shifts every bit of a non-zero value out of range, and -2^31 << 32 fits an int64
+        }
+        result = left << uint(right)
+    case EQ:
+        return leftValue == rightValue, nil
+    case NE:
+        return leftValue != rightValue, nil
+    case LT:
+        return leftValue < rightValue, nil
+    case LE:
+        return leftValue <= rightValue, nil
+    case GT:
+        return leftValue > rightValue, nil
+    case GE:
+        return leftValue >= rightValue, nil
+    default:
+        return nil, typeError(operator, leftValue, rightValue)
+    }
+    return interp.checkRange(result, operator, func() string {
+        return fmt.Sprintf("%d %s %d", leftValue, symbols[operator.TokenType], rightValue)
+    })
+}
+
+// floatOperation applies an arithmetic or comparison operator to two floats. An int operand is converted to
+// a float first, so 1.5 + 1 is 2.5. 
+func floatOperation(operator *token.Token, leftValue, rightValue float64) (interface{}, error) {
+    switch operator.TokenType {
+    case PLUS:
+        return leftValue + rightValue, nil
+    case MINUS:
+        return leftValue - rightValue, nil
+    case MUL:
+        return leftValue * rightValue, nil
+    case DIV:
+        if rightValue == 0 {
+            return nil, ErrDivisionByZero // div by 0 check
+        }
+        return leftValue / rightValue, nil
+    case POWER:
+        if leftValue == 0 && rightValue < 0 {
+            return nil, ErrDivisionByZero // 0^-1 is 1/0
+        }
+        result := math.Pow(leftValue, rightValue)
+        if math.IsNaN(result) {
+            return nil, fmt.Errorf("interpreter: %v ^ %v is undefined at column %d", leftValue, rightValue,
+                operator.Position + 1)
+        }
+        return result, nil
+    case EQ:
+        return leftValue == rightValue, nil
+    case NE:
+        return leftValue != rightValue, nil
+    case LT:
+        return leftValue < rightValue, nil
+    case LE:
+        return leftValue <= rightValue, nil
+    case GT:
+        return leftValue > rightValue, nil
+    case GE:
+        return leftValue >= rightValue, nil
+    default:
+        return nil, typeError(operator, leftValue, rightValue)
+    }
+}
+
+// power raises an integer to an integer power. A negative exponent gives a float (2^-1 is 0.5). Otherwise 
+// the power is computed by repeated squaring, only squaring when another bit of the exponent is left, so 
+// every intermediate product is at most as large as the result and an overflow of one is an overflow of the
+// result. With a word size, or when wrapping, the products simply wrap. 
+func (interp *Interpreter) power(operator *token.Token, base, exponent int) (interface{}, error) {
+    if exponent < 0 {
+        return floatOperation(operator, float64(base), float64(exponent))
+    }
+    if interp.Word != nil || interp.Overflow == OverflowWrap {
+        result, square := uint64(1), uint64(int64(base))
+        for n := exponent; n > 0; n >>= 1 {
+            if n & 1 == 1 {
+                result *= square
+            }
+            square *= square
+        }
+        if interp.Word != nil {
+            return interp.Word.Normalize(result), nil
+        }
+        return int(int32(result)), nil
+    }
+    result, square := int64(1), int64(base)
+    for n := exponent; n > 0; {
+        if n & 1 == 1 {
+            result *= square
+            if result < MinInt || result > MaxInt {
+                break
+            }
+        }
+        if n >>= 1; n > 0 {
+            square *= square
+            if square > MaxInt {
+                result = square // the result is at least as large 
+                break
+            }
+        }
+    }
+    if result >= MinInt && result <= MaxInt {
+        return int(result), nil
+    }
+    if interp.Overflow == OverflowSaturate {
+        if base < 0 && exponent % 2 == 1 {
+            return MinInt, nil
+        }
+        return MaxInt, nil
+    }
+    return nil, &OverflowError{Operation: fmt.Sprintf("%d ^ %d", base, exponent), Position: operator.Position}
+}
+
+// percentOperation adds a percentage of a value to it, or subtracts it, the way a desk calculator does:
+// 200 + 10% is 200 + 200 * 10 / 100 = 220. 
+func (interp *Interpreter) percentOperation(operator *token.Token, leftResult interface{}, percent *ast.UnaryOperation) (interface{}, error) {
+    rightResult, err := interp.visit(percent.Expr)
+    if err != nil {
+        return nil, err
+    }
+    var left, right float64
+    switch value := leftResult.(type) {
+    case int:
+        left = float64(value)
+    case float64:
+        left = value
+    default:
+        return nil, typeError(operator, leftResult, rightResult)
+    }
+    switch value := rightResult.(type) {
+    case int:
+        right = float64(value)
+    case float64:
+        right = value
+    default:
+        return nil, typeError(percent.Operator, rightResult)
+    }
+    return floatOperation(operator, left, left * right / 100)
+}
+
+// factorial computes n! one multiplication at a time, each of which is kept in range like any other integer
+// operation: an error as soon as the product overflows, or wrapped, saturated or truncated to the word size.
+// A product that has wrapped to 0 or saturated stays there, so the loop stops early and large factorials
+// are cheap in those modes. 
+func (interp *Interpreter) factorial(operator *token.Token, operand interface{}) (interface{}, error) {
+    var n int
+    switch value := operand.(type) {
+    case int:
+        n = value
+    case float64:
+        if value != math.Trunc(value) {
+            return nil, fmt.Errorf("interpreter: factorial of non-integer %v at column %d", value, operator.Position + 1)
+        }
+        if value > MaxInt {
+            return nil, &OverflowError{Operation: fmt.Sprintf("%v!", value), Position: operator.Position}
+        }
+        n = int(value)
+    default:
+        return nil, typeError(operator, operand)
+    }
+    if n < 0 {
+        if interp.Word != nil && !interp.Word.Signed {
+            return 0, nil // a uint64 above the int range, its factorial has more than 64 factors of 2
+        }
+        return nil, fmt.Errorf("interpreter: factorial of negative number %d at column %d", n, operator.Position + 1)
+    }
+    result := 1
+    for i := 2; i <= n; i++ {
+        var err error
+        if interp.Word != nil {
+            result = interp.Word.Normalize(uint64(int64(result)) * uint64(i))
+        } else if result, err = interp.checkRange(int64(result) * int64(i), operator, func() string {
+            return fmt.Sprintf("%d!", n)
+        }); err != nil {
+            return nil, err
+        }
+        if result == 0 || interp.Overflow == OverflowSaturate && result == MaxInt && interp.Word == nil {
+            break
+        }
+    }
+    return result, nil
+}
+
+// booleanOperation applies == or != to two booleans, no other binary operator accepts booleans
+func booleanOperation(operator *token.Token, leftValue, rightValue bool) (interface{}, error) {
+    switch operator.TokenType {
+    case EQ:
+        return leftValue == rightValue, nil
+    case NE:
+        return leftValue != rightValue, nil
+    default:
+        return nil, typeError(operator, leftValue, rightValue)
+    }
+}
+
+// checkRange brings the exact result of an integer operation back into [MinInt, MaxInt] according to the 
+// interpreter's overflow mode. operation is only called to describe the operation when reporting an error. 
+func (interp *Interpreter) checkRange(result int64, operator *token.Token, operation func() string) (int, error) {
+    if result >= MinInt && result <= MaxInt {
+        return int(result), nil
+    }
+    switch interp.Overflow {
+    case OverflowWrap:
+        return int(int32(result)), nil
+    case OverflowSaturate:
+        if result < MinInt {
+            return MinInt, nil
+        }
+        return MaxInt, nil
+    default:
+        return 0, &OverflowError{Operation: operation(), Position: operator.Position}
+    }
+}
+
+// typeName returns the name of a value's type as used in error messages
+func typeName(value interface{}) string {
+    switch value.(type) {
+    case int:
+        return "int"
+    case float64:
+        return "float"
+    case bool:
+        return "bool"
+    case *Matrix:
+        return "matrix"
+    case *List:
+        return "list"
+    case complex128:
+        return "complex"
+    case *Quantity:
+        return "quantity"
+    default:
+        return fmt.Sprintf("%T", value)
+    }
+}
+
+// typeError reports an operator applied to operand types it does not support 
+func typeError(operator *token.Token, operands ...interface{}) error {
+    if len(operands) == 1 {
+        return fmt.Errorf("interpreter: type error: cannot apply %s to %s at column %d",
+            symbols[operator.TokenType], typeName(operands[0]), operator.Position + 1)
+    }
+    return fmt.Errorf("interpreter: type error: cannot apply %s to %s and %s at column %d",
+        symbols[operator.TokenType], typeName(operands[0]), typeName(operands[1]), operator.Position + 1)
+}
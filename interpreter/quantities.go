@@ -0,0 +1,169 @@
+package interpreter
+
+/*
+
+Quantities: numbers with a unit of measure, 5 km or 9.8 m/s^2 (see the units package). Multiplying and
+dividing combine the units (5 km / 2 h is 2.5 km/h), adding, subtracting and comparing need units of the
+same dimension and convert the right operand into the unit of the left one (1 km + 500 m is 1.5 km), and
+x to unit converts a quantity into another unit (3 ft to m). A quantity whose units cancel out is a number
+again: 5 km / 2 m is 2500. The value of a quantity is always a float, so 5 km / 2 h is not an integer
+division.
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/token"
+    "calculator/units"
+    "fmt"
+    "math"
+)
+
+// Quantity is a number with a unit
+type Quantity struct {
+    Value float64
+    Unit  units.Unit
+}
+
+// Format writes the quantity as it is written in the input, 2.5 km/h, with the number written by number
+func (q *Quantity) Format(number func(value float64) string) string {
+    return number(q.Value) + " " + q.Unit.String()
+}
+
+func (q *Quantity) String() string {
+    return q.Format(func(value float64) string { return fmt.Sprintf("%v", value) })
+}
+
+// measured returns a value with a unit: a number if the unit is empty or its dimension is, a quantity
+// otherwise
+func measured(value float64, unit units.Unit) interface{} {
+    if len(unit) == 0 {
+        return value
+    }
+    if unit.Dimension() == (units.Dimension{}) {
+        return value * unit.Factor() // km/m
+    }
+    return &Quantity{Value: value, Unit: unit}
+}
+
+// Visit Measure: the number with its unit. A unit written right after the number in implicit multiplication
+// mode (2m) that is also the name of a variable could be a product as well, which is an error. Constants
+// cannot be chosen by the user, so after a number a unit named like one is the unit: 2g is 2 grams.
+func (interp *Interpreter) VisitMeasure(node *ast.Measure) (interface{}, error) {
+    if name := node.Attached; name != "" {
+        if _, ok := interp.Variables[name]; ok {
+            number := node.Token.Literal
+            return nil, fmt.Errorf("interpreter: %s%s is ambiguous, %s is a unit and a variable: write %s %s for the "+
+                "unit or %s * %s for the product at column %d", number, name, name, number, name, number, name,
+                node.Token.Position + 1)
+        }
+    }
+    value, err := interp.visit(node.Value)
+    if err != nil {
+        return nil, err
+    }
+    number, ok := toQuantity(value)
+    if !ok {
+        return nil, typeError(node.Token, value) // a literal is always a number
+    }
+    return measured(number.Value, node.Unit), nil
+}
+
+// Visit Conversion: the quantity in another unit of the same dimension
+func (interp *Interpreter) VisitConversion(node *ast.Conversion) (interface{}, error) {
+    value, err := interp.visit(node.Expr)
+    if err != nil {
+        return nil, err
+    }
+    q, ok := toQuantity(value)
+    if !ok {
+        return nil, fmt.Errorf("interpreter: type error: cannot convert %s to %s at column %d", typeName(value),
+            node.Unit, node.Token.Position + 1)
+    }
+    if q.Unit.Dimension() != node.Unit.Dimension() {
+        return nil, fmt.Errorf("interpreter: cannot convert %s to %s at column %d", unitName(q.Unit), node.Unit,
+            node.Token.Position + 1)
+    }
+    return measured(convert(q.Value, q.Unit, node.Unit), node.Unit), nil
+}
+
+// convert converts a value from one unit into another of the same dimension. The factors are decimals, so
+// the conversion is done exactly and rounded once: 3 ft is 0.9144 m, not 0.9144000000000001 m.
+func convert(value float64, from, to units.Unit) float64 {
+    v, f, t := rational(value), rational(from.Factor()), rational(to.Factor())
+    if v == nil || f == nil || t == nil {
+        return value * from.Factor() / to.Factor() // infinite
+    }
+    result, _ := v.Mul(v, f).Quo(v, t).Float64()
+    return result
+}
+
+// toQuantity returns a quantity, or a number as a quantity without a unit
+func toQuantity(value interface{}) (*Quantity, bool) {
+    switch v := value.(type) {
+    case *Quantity:
+        return v, true
+    case int:
+        return &Quantity{Value: float64(v)}, true
+    case float64:
+        return &Quantity{Value: v}, true
+    }
+    return nil, false
+}
+
+// unitName names a unit in error messages, a number has none
+func unitName(unit units.Unit) string {
+    if len(unit) == 0 {
+        return "a number"
+    }
+    return unit.String()
+}
+
+// what the operators that need units of the same dimension do, for error messages
+var unitVerbs = map[string]string{
+    PLUS: "add", MINUS: "subtract", EQ: "compare", NE: "compare", LT: "compare", LE: "compare", GT: "compare",
+    GE: "compare",
+}
+
+// quantityOperation applies an operator where one operand, or both, is a quantity and the other a number
+func (interp *Interpreter) quantityOperation(operator *token.Token, leftResult, rightResult interface{}) (interface{}, error) {
+    left, leftOk := toQuantity(leftResult)
+    right, rightOk := toQuantity(rightResult)
+    if !leftOk || !rightOk {
+        return nil, typeError(operator, leftResult, rightResult)
+    }
+    switch operator.TokenType {
+    case MUL, DOTMUL:
+        return measured(left.Value * right.Value, left.Unit.Times(right.Unit, 1)), nil
+    case DIV:
+        if right.Value == 0 {
+            return nil, ErrDivisionByZero
+        }
+        return measured(left.Value / right.Value, left.Unit.Times(right.Unit, -1)), nil
+    case POWER:
+        if len(right.Unit) > 0 {
+            return nil, fmt.Errorf("interpreter: exponent %v has a unit at column %d", right, operator.Position + 1)
+        }
+        if right.Value != math.Trunc(right.Value) || math.Abs(right.Value) > MaxInt {
+            return nil, fmt.Errorf("interpreter: cannot raise %s to the power %v at column %d", left.Unit, right.Value,
+                operator.Position + 1)
+        }
+        return measured(math.Pow(left.Value, right.Value), left.Unit.Pow(int(right.Value))), nil
+    }
+    verb, ok := unitVerbs[operator.TokenType]
+    if !ok {
+        return nil, typeError(operator, leftResult, rightResult)
+    }
+    if left.Unit.Dimension() != right.Unit.Dimension() {
+        return nil, fmt.Errorf("interpreter: cannot %s %s and %s at column %d", verb, unitName(left.Unit),
+            unitName(right.Unit), operator.Position + 1)
+    }
+    converted := convert(right.Value, right.Unit, left.Unit)
+    switch operator.TokenType {
+    case PLUS:
+        return measured(left.Value + converted, left.Unit), nil
+    case MINUS:
+        return measured(left.Value - converted, left.Unit), nil
+    }
+    return floatOperation(operator, left.Value, converted)
+}
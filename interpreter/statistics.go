@@ -0,0 +1,390 @@
+package interpreter
+
+/*
+
+The statistics functions, of lists (or matrices, all of their elements):
+
+    mean(xs), median(xs), mode(xs)
+    variance(xs), stddev(xs)       sample variance and standard deviation (divided by n - 1)
+    pvariance(xs), pstddev(xs)     population variance and standard deviation (divided by n)
+    percentile(xs, p)              p from 0 to 100, interpolating linearly between the sorted numbers
+    correlation(xs, ys)            Pearson's correlation coefficient
+    regression(xs, ys)             the least squares line y = a x + b, as the list [a, b]
+
+By default they are computed on floats with updating algorithms (Welford's for the mean, the variance and
+the co-moments) that do not lose precision the way summing squares does. In rational mode (Rational on the
+interpreter) they are computed exactly on big.Rat, with floats taken as the decimals they print as, and
+rounded once at the end: a whole result of numbers that were all ints is an int. Square roots are exact
+when the exact result has one (pstddev([1, 3]) is 1), and rounded otherwise.
+
+*/
+
+import (
+    "calculator/ast"
+    "fmt"
+    "math"
+    "math/big"
+    "sort"
+    "strconv"
+)
+
+var statisticsBuiltins = map[string]func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error){
+    "mean": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        return interp.oneSample(node, arguments, 1, func(xs []interface{}) (interface{}, error) {
+            if interp.Rational {
+                return interp.exact(node, xs, mean(rationals(xs)))
+            }
+            m, _ := welford(xs)
+            return m, nil
+        })
+    },
+    "median": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        return interp.oneSample(node, arguments, 1, func(xs []interface{}) (interface{}, error) {
+            return interp.percentile(node, sorted(xs), 50)
+        })
+    },
+    "mode": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        return interp.oneSample(node, arguments, 1, func(xs []interface{}) (interface{}, error) {
+            // the most frequent number, the smallest of them if several are as frequent
+            xs = sorted(xs)
+            best, count, bestCount := xs[0], 0, 0
+            for i, x := range xs {
+                if i > 0 && float(x) == float(xs[i - 1]) {
+                    count++
+                } else {
+                    count = 1
+                }
+                if count > bestCount {
+                    best, bestCount = x, count
+                }
+            }
+            return best, nil
+        })
+    },
+    "variance": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        return interp.oneSample(node, arguments, 2, func(xs []interface{}) (interface{}, error) {
+            return interp.variance(node, xs, 1, false)
+        })
+    },
+    "pvariance": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        return interp.oneSample(node, arguments, 1, func(xs []interface{}) (interface{}, error) {
+            return interp.variance(node, xs, 0, false)
+        })
+    },
+    "stddev": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        return interp.oneSample(node, arguments, 2, func(xs []interface{}) (interface{}, error) {
+            return interp.variance(node, xs, 1, true)
+        })
+    },
+    "pstddev": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        return interp.oneSample(node, arguments, 1, func(xs []interface{}) (interface{}, error) {
+            return interp.variance(node, xs, 0, true)
+        })
+    },
+    "percentile": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        if len(arguments) != 2 || !isNumber(arguments[1]) {
+            return nil, fmt.Errorf("interpreter: usage: percentile(list, p) with p from 0 to 100 at column %d",
+                node.Token.Position + 1)
+        }
+        if p := float(arguments[1]); !(p >= 0 && p <= 100) {
+            return nil, fmt.Errorf("interpreter: percentile %v is not from 0 to 100 at column %d", arguments[1],
+                node.Token.Position + 1)
+        }
+        return interp.oneSample(node, arguments[:1], 1, func(xs []interface{}) (interface{}, error) {
+            return interp.percentile(node, sorted(xs), arguments[1])
+        })
+    },
+    "correlation": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        return interp.twoSamples(node, arguments, func(xs, ys []interface{}) (interface{}, error) {
+            if interp.Rational {
+                _, _, sxx, syy, sxy := comoments(rationals(xs), rationals(ys))
+                if sxx.Sign() == 0 || syy.Sign() == 0 {
+                    return nil, constantError(node)
+                }
+                // r = sxy / sqrt(sxx syy)
+                r := exactSqrt(new(big.Rat).Mul(sxx, syy))
+                if root, ok := r.(*big.Rat); ok {
+                    return interp.exact(node, append(xs, ys...), new(big.Rat).Quo(sxy, root))
+                }
+                s, _ := sxy.Float64()
+                return s / r.(float64), nil
+            }
+            _, _, sxx, syy, sxy := floatComoments(xs, ys)
+            if sxx == 0 || syy == 0 {
+                return nil, constantError(node)
+            }
+            return sxy / math.Sqrt(sxx * syy), nil
+        })
+    },
+    "regression": func(interp *Interpreter, node *ast.Call, arguments []interface{}) (interface{}, error) {
+        return interp.twoSamples(node, arguments, func(xs, ys []interface{}) (interface{}, error) {
+            if interp.Rational {
+                mx, my, sxx, _, sxy := comoments(rationals(xs), rationals(ys))
+                if sxx.Sign() == 0 {
+                    return nil, constantError(node)
+                }
+                slope := new(big.Rat).Quo(sxy, sxx)
+                intercept := new(big.Rat).Sub(my, new(big.Rat).Mul(slope, mx))
+                line := &List{Elements: make([]interface{}, 2)}
+                for i, r := range []*big.Rat{slope, intercept} {
+                    value, err := interp.exact(node, append(xs, ys...), r)
+                    if err != nil {
+                        return nil, err
+                    }
+                    line.Elements[i] = value
+                }
+                return line, nil
+            }
+            mx, my, sxx, _, sxy := floatComoments(xs, ys)
+            if sxx == 0 {
+                return nil, constantError(node)
+            }
+            slope := sxy / sxx
+            return &List{Elements: []interface{}{slope, my - slope * mx}}, nil
+        })
+    },
+}
+
+// callStatisticsBuiltin applies a statistics function to its evaluated arguments
+func (interp *Interpreter) callStatisticsBuiltin(node *ast.Call, arguments []interface{}) (interface{}, error) {
+    return statisticsBuiltins[node.Name](interp, node, arguments)
+}
+
+// numbers returns the numbers of a list or matrix argument
+func numbers(node *ast.Call, argument interface{}) ([]interface{}, error) {
+    switch v := argument.(type) {
+    case *List:
+        return v.Elements, nil
+    case *Matrix:
+        return v.Elements, nil
+    }
+    return nil, fmt.Errorf("interpreter: type error: cannot apply %s to %s at column %d", node.Name, typeName(argument),
+        node.Token.Position + 1)
+}
+
+// oneSample applies f to the numbers of the one list argument, of which there must be at least least
+func (interp *Interpreter) oneSample(node *ast.Call, arguments []interface{}, least int,
+    f func(xs []interface{}) (interface{}, error)) (interface{}, error) {
+    if len(arguments) != 1 {
+        return nil, fmt.Errorf("interpreter: %s takes 1 list, not %d arguments at column %d", node.Name, len(arguments),
+            node.Token.Position + 1)
+    }
+    xs, err := numbers(node, arguments[0])
+    if err != nil {
+        return nil, err
+    }
+    if len(xs) < least {
+        noun := "numbers"
+        if least == 1 {
+            noun = "number"
+        }
+        return nil, fmt.Errorf("interpreter: %s needs at least %d %s, not %d at column %d", node.Name, least, noun,
+            len(xs), node.Token.Position + 1)
+    }
+    if err := interp.finite(node, xs); err != nil {
+        return nil, err
+    }
+    return f(xs[:len(xs):len(xs)])
+}
+
+// twoSamples applies f to the numbers of two list arguments of the same length, at least 2
+func (interp *Interpreter) twoSamples(node *ast.Call, arguments []interface{},
+    f func(xs, ys []interface{}) (interface{}, error)) (interface{}, error) {
+    if len(arguments) != 2 {
+        return nil, fmt.Errorf("interpreter: %s takes 2 lists, not %d arguments at column %d", node.Name, len(arguments),
+            node.Token.Position + 1)
+    }
+    xs, err := numbers(node, arguments[0])
+    if err != nil {
+        return nil, err
+    }
+    ys, err := numbers(node, arguments[1])
+    if err != nil {
+        return nil, err
+    }
+    if len(xs) != len(ys) || len(xs) < 2 {
+        return nil, fmt.Errorf("interpreter: %s needs two lists of the same length, at least 2, not %d and %d at column %d",
+            node.Name, len(xs), len(ys), node.Token.Position + 1)
+    }
+    if err := interp.finite(node, append(xs[:len(xs):len(xs)], ys...)); err != nil {
+        return nil, err
+    }
+    return f(xs[:len(xs):len(xs)], ys)
+}
+
+// constantError reports a correlation or regression of numbers that do not vary
+func constantError(node *ast.Call) error {
+    return fmt.Errorf("interpreter: %s is undefined when all the numbers of a list are equal at column %d", node.Name,
+        node.Token.Position + 1)
+}
+
+// sorted returns a sorted copy of numbers
+func sorted(xs []interface{}) []interface{} {
+    result := make([]interface{}, len(xs))
+    copy(result, xs)
+    sort.SliceStable(result, func(i, j int) bool { return float(result[i]) < float(result[j]) })
+    return result
+}
+
+// hasFloat reports whether any of the numbers is a float
+func hasFloat(xs []interface{}) bool {
+    for _, x := range xs {
+        if _, ok := x.(float64); ok {
+            return true
+        }
+    }
+    return false
+}
+
+// welford returns the mean of the numbers and the sum of the squares of their differences from it
+func welford(xs []interface{}) (float64, float64) {
+    mean, m2 := 0.0, 0.0
+    for k, x := range xs {
+        delta := float(x) - mean
+        mean += delta / float64(k + 1)
+        m2 += delta * (float(x) - mean)
+    }
+    return mean, m2
+}
+
+// floatComoments returns the means of xs and ys, the sums of the squares of their differences from them
+// and the sum of the products of those differences
+func floatComoments(xs, ys []interface{}) (mx, my, sxx, syy, sxy float64) {
+    for k := range xs {
+        x, y := float(xs[k]), float(ys[k])
+        dx, dy := x - mx, y - my
+        mx += dx / float64(k + 1)
+        my += dy / float64(k + 1)
+        sxx += dx * (x - mx)
+        syy += dy * (y - my)
+        sxy += dx * (y - my)
+    }
+    return
+}
+
+// rational returns a number as a big.Rat, a float as the decimal it prints as (0.1 is 1/10)
+func rational(x interface{}) *big.Rat {
+    if i, ok := x.(int); ok {
+        return big.NewRat(int64(i), 1)
+    }
+    r, ok := new(big.Rat).SetString(strconv.FormatFloat(x.(float64), 'g', -1, 64))
+    if !ok {
+        return nil // not finite
+    }
+    return r
+}
+
+// rationals returns numbers as big.Rat, see rational
+func rationals(xs []interface{}) []*big.Rat {
+    result := make([]*big.Rat, len(xs))
+    for i, x := range xs {
+        result[i] = rational(x)
+    }
+    return result
+}
+
+// mean returns the exact mean
+func mean(xs []*big.Rat) *big.Rat {
+    sum := new(big.Rat)
+    for _, x := range xs {
+        sum.Add(sum, x)
+    }
+    return sum.Quo(sum, big.NewRat(int64(len(xs)), 1))
+}
+
+// comoments is floatComoments computed exactly
+func comoments(xs, ys []*big.Rat) (mx, my, sxx, syy, sxy *big.Rat) {
+    mx, my = mean(xs), mean(ys)
+    sxx, syy, sxy = new(big.Rat), new(big.Rat), new(big.Rat)
+    for k := range xs {
+        dx, dy := new(big.Rat).Sub(xs[k], mx), new(big.Rat).Sub(ys[k], my)
+        sxx.Add(sxx, new(big.Rat).Mul(dx, dx))
+        syy.Add(syy, new(big.Rat).Mul(dy, dy))
+        sxy.Add(sxy, new(big.Rat).Mul(dx, dy))
+    }
+    return
+}
+
+// finite checks in rational mode that the numbers can be computed with exactly, which infinities cannot
+func (interp *Interpreter) finite(node *ast.Call, xs []interface{}) error {
+    if !interp.Rational {
+        return nil
+    }
+    for _, x := range xs {
+        if f, ok := x.(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+            return fmt.Errorf("interpreter: %s of %v cannot be computed exactly at column %d", node.Name, f,
+                node.Token.Position + 1)
+        }
+    }
+    return nil
+}
+
+// exact converts an exact result back to a number: an int if it is whole and all the numbers it was computed
+// from were ints, a float otherwise
+func (interp *Interpreter) exact(node *ast.Call, xs []interface{}, r *big.Rat) (interface{}, error) {
+    return interp.number(node.Token, r, hasFloat(xs))
+}
+
+// exactSqrt returns the square root of r as a *big.Rat if it is rational, or as a float64
+func exactSqrt(r *big.Rat) interface{} {
+    num, denom := new(big.Int).Sqrt(r.Num()), new(big.Int).Sqrt(r.Denom())
+    if new(big.Int).Mul(num, num).Cmp(r.Num()) == 0 && new(big.Int).Mul(denom, denom).Cmp(r.Denom()) == 0 {
+        return new(big.Rat).SetFrac(num, denom)
+    }
+    f, _ := r.Float64()
+    return math.Sqrt(f)
+}
+
+// variance returns the variance of the numbers, the sum of the squares of their differences from the mean
+// divided by n - correction, or its square root, the standard deviation
+func (interp *Interpreter) variance(node *ast.Call, xs []interface{}, correction int, root bool) (interface{}, error) {
+    divisor := len(xs) - correction
+    if !interp.Rational {
+        _, m2 := welford(xs)
+        if root {
+            return math.Sqrt(m2 / float64(divisor)), nil
+        }
+        return m2 / float64(divisor), nil
+    }
+    rs := rationals(xs)
+    m := mean(rs)
+    v := new(big.Rat)
+    for _, x := range rs {
+        d := new(big.Rat).Sub(x, m)
+        v.Add(v, d.Mul(d, d))
+    }
+    v.Quo(v, big.NewRat(int64(divisor), 1))
+    if !root {
+        return interp.exact(node, xs, v)
+    }
+    if r, ok := exactSqrt(v).(*big.Rat); ok {
+        return interp.exact(node, xs, r)
+    }
+    return exactSqrt(v), nil
+}
+
+// percentile returns the p-th percentile of sorted numbers, interpolating between the two closest ranks:
+// with n numbers it is at rank (n - 1) p / 100 counting from 0. A percentile that falls on a number is that
+// number as it is.
+func (interp *Interpreter) percentile(node *ast.Call, xs []interface{}, p interface{}) (interface{}, error) {
+    if interp.Rational {
+        rank := new(big.Rat).Mul(big.NewRat(int64(len(xs) - 1), 100), rational(p))
+        low := new(big.Int).Quo(rank.Num(), rank.Denom()) // rank is not negative, so this is its floor
+        i := int(low.Int64())
+        fraction := new(big.Rat).Sub(rank, new(big.Rat).SetInt(low))
+        if fraction.Sign() == 0 {
+            return xs[i], nil
+        }
+        a, b := rational(xs[i]), rational(xs[i + 1])
+        result := new(big.Rat).Add(a, new(big.Rat).Mul(fraction, new(big.Rat).Sub(b, a)))
+        return interp.exact(node, append(xs, p), result)
+    }
+    rank := float64(len(xs) - 1) * float(p) / 100
+    i := int(math.Floor(rank))
+    fraction := rank - float64(i)
+    if fraction == 0 {
+        return xs[i], nil
+    }
+    a, b := float(xs[i]), float(xs[i + 1])
+    return a + fraction * (b - a), nil
+}
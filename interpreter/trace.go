@@ -0,0 +1,274 @@
+package interpreter
+
+/*
+
+The tracer records how an expression is reduced, one operation at a time, to "show the work":
+
+    2 * (16 - 8) / 2 - 1
+ =  2 * 8 / 2 - 1
+ =  16 / 2 - 1
+ =  8 - 1
+ =  7
+
+It runs the interpreter as usual and is told about every node the interpreter evaluates (see visit()). Each
+time a node has been reduced to a value, the statement being evaluated is written out again with every
+reduced node replaced by its value. Nodes that are evaluated more than once (loop bodies) are written out
+unreduced again each time they are entered.
+
+*/
+
+import (
+    "calculator/ast"
+    "context"
+    "fmt"
+    "strings"
+)
+
+// Step is one reduction: the statement being evaluated before and after a node was replaced by its value
+type Step struct {
+    Node       ast.ASTNode
+    Value      interface{}
+    Before     string
+    Expression string
+}
+
+type Tracer struct {
+    Interpreter *Interpreter
+    Format      func(value interface{}) string // writes the values in the expressions, fmt's %v if nil
+    Steps       []Step
+    statements  map[ast.ASTNode]bool        // the statements of the input
+    statement   ast.ASTNode                 // the statement being evaluated
+    reduced     map[ast.ASTNode]interface{} // values of the nodes of the statement reduced so far
+}
+
+func NewTracer(interp *Interpreter) *Tracer {
+    return &Tracer{Interpreter: interp}
+}
+
+// Evaluate evaluates the input of the tracer's interpreter like Interpreter.Evaluate, recording the steps
+func (t *Tracer) Evaluate() (interface{}, error) {
+    return t.EvaluateContext(context.Background())
+}
+
+// EvaluateContext is Evaluate with cancellation, see Interpreter.InterpretContext
+func (t *Tracer) EvaluateContext(ctx context.Context) (interface{}, error) {
+    t.Steps = nil
+    t.Interpreter.trace = t
+    defer func() { t.Interpreter.trace = nil }()
+    return t.Interpreter.EvaluateContext(ctx)
+}
+
+// Lines returns the steps as the successive expressions, the first expression of each statement on its own
+// and each rewritten one after an '='
+func (t *Tracer) Lines() []string {
+    var lines []string
+    for i, step := range t.Steps {
+        if i == 0 || step.Before != t.Steps[i - 1].Expression {
+            lines = append(lines, "   " + step.Before)
+        }
+        lines = append(lines, "=  " + step.Expression)
+    }
+    return lines
+}
+
+// start is called by the interpreter with the root of the parsed input
+func (t *Tracer) start(root ast.ASTNode) {
+    t.statements = make(map[ast.ASTNode]bool)
+    t.reduced = make(map[ast.ASTNode]interface{})
+    t.statement = root
+    if block, ok := root.(*ast.Block); ok {
+        for _, statement := range block.Statements {
+            t.statements[statement] = true
+        }
+    }
+}
+
+// enter is called by the interpreter before it evaluates a node. A node that was already reduced is being
+// evaluated again, so it and its children are written unreduced until they are reduced again.
+func (t *Tracer) enter(node ast.ASTNode) {
+    if t.statements[node] {
+        t.statement = node
+    }
+    if _, ok := t.reduced[node]; ok {
+        ast.Inspect(node, func(n ast.ASTNode) bool {
+            delete(t.reduced, n)
+            return true
+        })
+    }
+}
+
+// reduce is called by the interpreter once a node has been evaluated. Literals are already values, and
+// statements (assignments, loops, ...) keep being written out as they are, so they make no step.
+func (t *Tracer) reduce(node ast.ASTNode, value interface{}) {
+    switch node.(type) {
+    case *ast.NumberLiteral, *ast.FloatLiteral, *ast.ImaginaryLiteral, *ast.BooleanLiteral, *ast.ErrorNode:
+        return
+    }
+    before := t.render(t.statement, 0)
+    if !statement(node) && value != nil {
+        t.reduced[node] = value
+    }
+    expression := t.render(t.statement, 0)
+    if expression != before {
+        t.Steps = append(t.Steps, Step{Node: node, Value: value, Before: before, Expression: expression})
+    }
+}
+
+// statement reports whether a node is a statement rather than an expression
+func statement(node ast.ASTNode) bool {
+    switch node.(type) {
+    case *ast.Assignment, *ast.While, *ast.For, *ast.Break, *ast.FunctionDefinition:
+        return true
+    }
+    return false
+}
+
+// Source writes a node out as calculator input, with only the parentheses it needs. Parsing the text gives
+// the same tree back. 
+func Source(node ast.ASTNode) string {
+    return (&Tracer{}).render(node, 0)
+}
+
+// precedence of the operators as the parser builds them, higher binds tighter
+var precedence = map[string]int{
+    OR: 2, AND: 3, BITOR: 4, CARET: 5, BITAND: 6, EQ: 7, NE: 7, LT: 8, LE: 8, GT: 8, GE: 8, SHL: 9, SHR: 9,
+    PLUS: 10, MINUS: 10, MUL: 11, DIV: 11, DOTMUL: 11, POWER: 13,
+}
+
+const (
+    prefixPrecedence  = 12
+    postfixPrecedence = 14
+    atomPrecedence    = 15
+)
+
+// render writes a node out with the values of the reduced nodes in their place, adding parentheses where the
+// node binds less tightly than its parent (a parent precedence of 0 never needs them)
+func (t *Tracer) render(node ast.ASTNode, parent int) string {
+    text, own := t.write(node)
+    if own < parent {
+        return "(" + text + ")"
+    }
+    return text
+}
+
+// write writes a node out and returns the precedence of its outermost operator
+func (t *Tracer) write(node ast.ASTNode) (string, int) {
+    if value, ok := t.reduced[node]; ok {
+        text := fmt.Sprintf("%v", value)
+        if t.Format != nil {
+            text = t.Format(value)
+        }
+        if c, ok := value.(complex128); ok && real(c) != 0 {
+            return text, precedence[PLUS] // 1 + 2i
+        }
+        if strings.HasPrefix(text, "-") {
+            return text, prefixPrecedence
+        }
+        if _, ok := value.(*Quantity); ok {
+            return text, precedence[POWER] // (2 m)^2, not 2 m^2
+        }
+        return text, atomPrecedence
+    }
+    switch n := node.(type) {
+    case *ast.BinaryOperation:
+        own := precedence[n.Operator.TokenType]
+        if n.Operator.TokenType == POWER {
+            // right associative, and binds tighter than a prefix operator: (-2)^2, 2^(-1). The unit after an
+            // exponent belongs to the whole power, so an exponent with a unit needs parentheses: 2^(3 m)
+            exponent := own
+            if _, ok := n.RightChild.(*ast.Measure); ok {
+                exponent = own + 1
+            }
+            return t.render(n.LeftChild, own + 1) + " ^ " + t.render(n.RightChild, exponent), own
+        }
+        // operators are left associative, so a right operand of the same precedence needs parentheses
+        return t.render(n.LeftChild, own) + " " + symbols[n.Operator.TokenType] + " " + t.render(n.RightChild, own + 1), own
+    case *ast.LogicalOperation:
+        own := precedence[n.Operator.TokenType]
+        return t.render(n.LeftChild, own) + " " + symbols[n.Operator.TokenType] + " " + t.render(n.RightChild, own + 1), own
+    case *ast.UnaryOperation:
+        if n.Postfix {
+            return t.render(n.Expr, postfixPrecedence) + symbols[n.Operator.TokenType], postfixPrecedence
+        }
+        operand := t.render(n.Expr, prefixPrecedence)
+        if strings.HasPrefix(operand, "-") {
+            operand = "(" + operand + ")" // -(-3) rather than --3
+        }
+        return symbols[n.Operator.TokenType] + operand, prefixPrecedence
+    case *ast.Conditional:
+        if n.Token.TokenType == QUESTION {
+            return t.render(n.Condition, 2) + " ? " + t.render(n.Consequent, 1) + " : " + t.render(n.Alternative, 1), 1
+        }
+        return "if " + t.render(n.Condition, 0) + " then " + t.render(n.Consequent, 0) + " else " +
+            t.render(n.Alternative, 0), 1
+    case *ast.Block:
+        statements := make([]string, len(n.Statements))
+        for i, statement := range n.Statements {
+            statements[i] = t.render(statement, 0)
+        }
+        return "{ " + strings.Join(statements, "; ") + " }", atomPrecedence
+    case *ast.Assignment:
+        return n.Variable.Name + " = " + t.render(n.Value, 0), 0
+    case *ast.While:
+        return "while " + t.render(n.Condition, 0) + " " + t.render(n.Body, 0), 0
+    case *ast.For:
+        return "for " + n.Variable.Name + " in " + t.render(n.Start, 2) + ".." + t.render(n.End, 2) + " " +
+            t.render(n.Body, 0), 0
+    case *ast.Break:
+        return "break", 0
+    case *ast.Call:
+        if len(n.Arguments) == 1 {
+            if block, ok := n.Arguments[0].(*ast.Block); ok {
+                return n.Name + " " + t.render(block, 0), atomPrecedence // solve { x + y = 2; x - y = 0 }
+            }
+        }
+        arguments := make([]string, len(n.Arguments))
+        for i, argument := range n.Arguments {
+            arguments[i] = t.render(argument, 0)
+        }
+        return n.Name + "(" + strings.Join(arguments, ", ") + ")", atomPrecedence
+    case *ast.MatrixLiteral:
+        rows := make([]string, len(n.Rows))
+        for i, row := range n.Rows {
+            elements := make([]string, len(row))
+            for j, element := range row {
+                elements[j] = t.render(element, 0)
+            }
+            rows[i] = strings.Join(elements, ", ")
+        }
+        return "[" + strings.Join(rows, "; ") + "]", atomPrecedence
+    case *ast.ListLiteral:
+        elements := make([]string, len(n.Elements))
+        for i, element := range n.Elements {
+            elements[i] = t.render(element, 0)
+        }
+        if len(elements) == 1 {
+            return "[" + elements[0] + ",]", atomPrecedence
+        }
+        return "[" + strings.Join(elements, ", ") + "]", atomPrecedence
+    case *ast.Range:
+        // binds less tightly than || (2) and more than ?: (1)
+        return t.render(n.Start, 2) + ".." + t.render(n.End, 2), 1
+    case *ast.Index:
+        indices := make([]string, len(n.Indices))
+        for i, index := range n.Indices {
+            indices[i] = t.render(index, 0)
+        }
+        return t.render(n.Expr, postfixPrecedence) + "[" + strings.Join(indices, ", ") + "]", postfixPrecedence
+    case *ast.Measure:
+        return t.render(n.Value, 0) + " " + n.Unit.String(), precedence[POWER]
+    case *ast.Conversion:
+        // binds less tightly than ?: (1), so a conditional is converted as a whole in parentheses
+        return t.render(n.Expr, 2) + " to " + n.Unit.String(), 1
+    case *ast.Equation:
+        return t.render(n.Left, 0) + " = " + t.render(n.Right, 0), 0
+    case *ast.FunctionDefinition:
+        parameters := make([]string, len(n.Parameters))
+        for i, parameter := range n.Parameters {
+            parameters[i] = parameter.Name
+        }
+        return n.Name + "(" + strings.Join(parameters, ", ") + ") = " + t.render(n.Body, 0), 0
+    default:
+        return node.String(), atomPrecedence // literals, variables and result references
+    }
+}
@@ -0,0 +1,161 @@
+package interpreter
+
+/*
+
+Fixed-width integer types for programmer mode. When the interpreter has a WordSize, every integer result is
+truncated to the width of the word and wraps around exactly like the hardware would, instead of being
+checked against the 32-bit range of the default mode. Values are stored in an int sign extended from the
+word's width (signed words) or zero extended (unsigned words); uint64 values above the int64 range are kept
+as their bit pattern. 
+
+*/
+
+import (
+    "calculator/token"
+    "fmt"
+    "strconv"
+)
+
+type WordSize struct {
+    Name   string
+    Bits   int
+    Signed bool
+}
+
+var (
+    Int8   = &WordSize{Name: "int8", Bits: 8, Signed: true}
+    Int16  = &WordSize{Name: "int16", Bits: 16, Signed: true}
+    Int32  = &WordSize{Name: "int32", Bits: 32, Signed: true}
+    Int64  = &WordSize{Name: "int64", Bits: 64, Signed: true}
+    Uint8  = &WordSize{Name: "uint8", Bits: 8, Signed: false}
+    Uint16 = &WordSize{Name: "uint16", Bits: 16, Signed: false}
+    Uint32 = &WordSize{Name: "uint32", Bits: 32, Signed: false}
+    Uint64 = &WordSize{Name: "uint64", Bits: 64, Signed: false}
+)
+
+var wordSizes = []*WordSize{Int8, Int16, Int32, Int64, Uint8, Uint16, Uint32, Uint64}
+
+// ParseWordSize returns the word size with the given name (int8 ... int64, uint8 ... uint64)
+func ParseWordSize(name string) (*WordSize, error) {
+    for _, w := range wordSizes {
+        if w.Name == name {
+            return w, nil
+        }
+    }
+    return nil, fmt.Errorf("interpreter.ParseWordSize(): unknown word size: %s", name)
+}
+
+func (w *WordSize) String() string {
+    return w.Name
+}
+
+// mask has the low Bits bits set 
+func (w *WordSize) mask() uint64 {
+    if w.Bits == 64 {
+        return ^uint64(0)
+    }
+    return 1 << uint(w.Bits) - 1
+}
+
+// pattern returns the bits of a stored value, limited to the width of the word 
+func (w *WordSize) pattern(value int) uint64 {
+    return uint64(int64(value)) & w.mask()
+}
+
+// Normalize truncates bits to the width of the word, and sign extends them for a signed word, returning the
+// value as it is stored by the interpreter
+func (w *WordSize) Normalize(bits uint64) int {
+    bits &= w.mask()
+    if w.Signed && w.Bits < 64 && bits >> uint(w.Bits - 1) & 1 == 1 {
+        bits |= ^w.mask()
+    }
+    return int(int64(bits))
+}
+
+// operation applies an arithmetic, bitwise or comparison operator to two values of this word size. Addition,
+// subtraction and multiplication produce the same low bits for signed and unsigned operands, only division,
+// right shifts and comparisons depend on the signedness of the word. 
+func (w *WordSize) operation(operator *token.Token, leftValue, rightValue int) (interface{}, error) {
+    left, right := w.pattern(leftValue), w.pattern(rightValue)
+    switch operator.TokenType {
+    case PLUS:
+        return w.Normalize(left + right), nil
+    case MINUS:
+        return w.Normalize(left - right), nil
+    case MUL:
+        return w.Normalize(left * right), nil
+    case DIV:
+        if right == 0 {
+            return nil, ErrDivisionByZero // div by 0 check
+        }
+        if w.Signed {
+            return w.Normalize(uint64(int64(leftValue) / int64(rightValue))), nil
+        }
+        return w.Normalize(left / right), nil
+    case BITAND:
+        return w.Normalize(left & right), nil
+    case BITOR:
+        return w.Normalize(left | right), nil
+    case CARET:
+        return w.Normalize(left ^ right), nil
+    case SHL, SHR:
+        if w.Signed && rightValue < 0 {
+            return nil, fmt.Errorf("interpreter: negative shift count %d at column %d", rightValue, operator.Position + 1)
+        }
+        if operator.TokenType == SHL {
+            return w.Normalize(left << right), nil
+        }
+        if w.Signed {
+            return w.Normalize(uint64(int64(leftValue) >> right)), nil // arithmetic shift
+        }
+        return w.Normalize(left >> right), nil
+    }
+    if w.Signed {
+        return compare(operator, int64(leftValue), int64(rightValue))
+    }
+    return compare(operator, left, right)
+}
+
+// compare applies a comparison operator to two signed or two unsigned integers 
+func compare[T int64 | uint64](operator *token.Token, left, right T) (interface{}, error) {
+    switch operator.TokenType {
+    case EQ:
+        return left == right, nil
+    case NE:
+        return left != right, nil
+    case LT:
+        return left < right, nil
+    case LE:
+        return left <= right, nil
+    case GT:
+        return left > right, nil
+    case GE:
+        return left >= right, nil
+    default:
+        return nil, typeError(operator, 0, 0)
+    }
+}
+
+// Format writes a value of this word size in base 2, 8, 10 or 16. Other bases than 10 show the bit pattern 
+// with a 0b, 0o or 0x prefix, so negative values of a signed word appear in two's complement. 
+func (w *WordSize) Format(value int, base int) string {
+    if base == 10 {
+        if w.Signed {
+            return strconv.FormatInt(int64(value), 10)
+        }
+        return strconv.FormatUint(w.pattern(value), 10)
+    }
+    return prefixes[base] + strconv.FormatUint(w.pattern(value), base)
+}
+
+// literal prefixes for the bases integers can be formatted in 
+var prefixes = map[int]string{2: "0b", 8: "0o", 10: "", 16: "0x"}
+
+// FormatInteger writes an integer of the default mode in base 2, 8, 10 or 16, with a sign for negative 
+// values (-0x1f)
+func FormatInteger(value int, base int) string {
+    if value < 0 {
+        return "-" + prefixes[base] + strconv.FormatUint(uint64(-int64(value)), base)
+    }
+    return prefixes[base] + strconv.FormatUint(uint64(value), base)
+}
@@ -8,6 +8,7 @@ encountered, then it returns an error. Otherwise it returns a token with a token
 import (
      "calculator/token"
      "fmt"
+     "strings"
      "unicode"
      "strconv"
 )
@@ -21,8 +22,63 @@ const (
     LPAR    = "LPAR"
     RPAR    = "RPAR"
     EOF     = "EOF"
+    BOOLEAN = "BOOLEAN"
+    EQ      = "EQ"
+    NE      = "NE"
+    LT      = "LT"
+    LE      = "LE"
+    GT      = "GT"
+    GE      = "GE"
+    AND     = "AND"
+    OR      = "OR"
+    NOT     = "NOT"
+    IDENT   = "IDENT"
+    QUESTION = "QUESTION"
+    COLON   = "COLON"
+    IF      = "IF"
+    THEN    = "THEN"
+    ELSE    = "ELSE"
+    SEMI    = "SEMI"
+    LBRACE  = "LBRACE"
+    RBRACE  = "RBRACE"
+    ASSIGN  = "ASSIGN"
+    DOTDOT  = "DOTDOT"
+    WHILE   = "WHILE"
+    FOR     = "FOR"
+    IN      = "IN"
+    BREAK   = "BREAK"
+    BITAND  = "BITAND"
+    BITOR   = "BITOR"
+    CARET   = "CARET"
+    TILDE   = "TILDE"
+    SHL     = "SHL"
+    SHR     = "SHR"
+    FLOAT   = "FLOAT"
+    PERCENT = "PERCENT"
+    FACTORIAL = "FACTORIAL"
+    HISTORY = "HISTORY"
+    COMMA   = "COMMA"
+    LBRACKET = "LBRACKET"
+    RBRACKET = "RBRACKET"
+    DOTMUL  = "DOTMUL"
+    IMAGINARY = "IMAGINARY"
+    TO      = "TO"
 )
 
+// keywords: words in the input that map to a token 
+var keywords = map[string]*token.Token{
+    "true":  {TokenType: BOOLEAN, Value: true},
+    "false": {TokenType: BOOLEAN, Value: false},
+    "if":    {TokenType: IF, Value: "if"},
+    "then":  {TokenType: THEN, Value: "then"},
+    "else":  {TokenType: ELSE, Value: "else"},
+    "while": {TokenType: WHILE, Value: "while"},
+    "for":   {TokenType: FOR, Value: "for"},
+    "in":    {TokenType: IN, Value: "in"},
+    "break": {TokenType: BREAK, Value: "break"},
+    "to":    {TokenType: TO, Value: "to"},
+}
+
 type Lexer struct {
     Input string 
     Position int
@@ -48,76 +104,333 @@ func (lex *Lexer) GetNextChar() {
     return 
 }
 
+// skip white space other than newlines, which separate statements 
 func (lex *Lexer) SkipWhiteSpace() {
-    for lex.CurrentChar != 0 && unicode.IsSpace(rune(lex.CurrentChar)) {
+    for lex.CurrentChar != 0 && lex.CurrentChar != '\n' && unicode.IsSpace(rune(lex.CurrentChar)) {
+        lex.GetNextChar()
+    }
+    return
+}
+
+// skip a # comment up to (but not including) the end of the line 
+func (lex *Lexer) SkipComment() {
+    for lex.CurrentChar != 0 && lex.CurrentChar != '\n' {
         lex.GetNextChar()
     }
     return
 }
 
-// parse multi-digit integer 
+// Return the character after the current one without advancing 
+func (lex *Lexer) Peek() byte {
+    if lex.Position + 1 > len(lex.Input) - 1 {
+        return 0
+    }
+    return lex.Input[lex.Position + 1]
+}
+
+// read a word made of letters, digits and underscores 
+func (lex *Lexer) Word() string {
+    start := lex.Position
+    for lex.CurrentChar != 0 && (isLetter(lex.CurrentChar) || unicode.IsDigit(rune(lex.CurrentChar))) {
+        lex.GetNextChar()
+    }
+    return lex.Input[start:lex.Position]
+}
+
+func isLetter(c byte) bool {
+    return c == '_' || unicode.IsLetter(rune(c))
+}
+
+// integer literal prefixes and the base they select 
+var bases = map[byte]int{'x': 16, 'X': 16, 'b': 2, 'B': 2, 'o': 8, 'O': 8}
+var baseNames = map[int]string{16: "hexadecimal", 2: "binary", 8: "octal", 10: "decimal"}
+
+// parse multi-digit integer: decimal, or hexadecimal, binary or octal with a 0x, 0b or 0o prefix. Digits
+// may be separated by single underscores (1_000_000, 0xFF_FF). 
 func (lex *Lexer) Integer() (int, error) {
+    start := lex.Position
+    base := 10
+    if lex.CurrentChar == '0' && bases[lex.Peek()] != 0 {
+        base = bases[lex.Peek()]
+        lex.GetNextChar()
+        lex.GetNextChar()
+    }
+    digitsStart := lex.Position
+
+    // a prefixed literal runs over letters too, so 0b102 and 0xFG are reported as one malformed literal 
+    for lex.CurrentChar != 0 && (unicode.IsDigit(rune(lex.CurrentChar)) || lex.CurrentChar == '_' ||
+        base != 10 && unicode.IsLetter(rune(lex.CurrentChar))) {
+        lex.GetNextChar()
+    }
+    literal := lex.Input[start:lex.Position]
+    digits := lex.Input[digitsStart:lex.Position]
+
+    malformed := func(position int, reason string) error {
+        return fmt.Errorf("lexer.Integer(): malformed %s literal %s: %s at column %d",
+            baseNames[base], literal, reason, position + 1)
+    }
+    if digits == "" {
+        return 0, malformed(digitsStart, "missing digits")
+    }
     integerString := ""
-    for lex.CurrentChar != 0 && unicode.IsDigit(rune(lex.CurrentChar)) {
-        integerString += string(lex.CurrentChar)
+    for i := 0; i < len(digits); i++ {
+        c := digits[i]
+        if c == '_' {
+            if i == 0 || i == len(digits) - 1 || digits[i - 1] == '_' {
+                return 0, malformed(digitsStart + i, "'_' must separate digits")
+            }
+            continue
+        }
+        if _, err := strconv.ParseUint(string(c), base, 8); err != nil {
+            return 0, malformed(digitsStart + i, fmt.Sprintf("invalid digit '%c'", c))
+        }
+        integerString += string(c)
+    }
+    // literals up to 64 bits are accepted for the unsigned word sizes of programmer mode, values above the
+    // int64 range keep their bit pattern. The interpreter checks the range for the word size in use.
+    bits, err := strconv.ParseUint(integerString, base, 64)
+    if err != nil {
+        return 0, fmt.Errorf("lexer.Integer(): failed to convert %s to a 64-bit integer at column %d", literal, start + 1)
+    }
+    return int(int64(bits)), nil
+}
+
+// parse the fraction of a decimal literal whose integer part started at start, e.g. the .5 of 12.5
+func (lex *Lexer) Fraction(start int) (float64, error) {
+    lex.GetNextChar() // skip the '.'
+    for lex.CurrentChar != 0 && (unicode.IsDigit(rune(lex.CurrentChar)) || lex.CurrentChar == '_') {
         lex.GetNextChar()
     }
-    integerA, err := strconv.ParseInt(integerString, 10, 32)
-    integer := int(integerA)
-//    integer, err := strconv.Atoi(integerString)
+    literal := lex.Input[start:lex.Position]
+    if strings.HasSuffix(literal, "_") || strings.Contains(literal, "__") || strings.Contains(literal, "._") {
+        return 0, fmt.Errorf("lexer.Fraction(): malformed decimal literal %s: '_' must separate digits at column %d",
+            literal, start + 1)
+    }
+    number, err := strconv.ParseFloat(strings.ReplaceAll(literal, "_", ""), 64)
     if err != nil {
-        return 0, fmt.Errorf("failed to convert input to integer %w",err)
+        return 0, fmt.Errorf("lexer.Fraction(): failed to convert %s to a number at column %d", literal, start + 1)
     }
-    return integer, nil    
+    return number, nil
 }
 
 // Creates a token based on current character in the input, if the character read is not in the alphabet,
-// then an error is returned, otherwise it returns the token.
+// then an error is returned, otherwise it returns the token. Each token records where it started in the input.
 func (lex *Lexer) GetNextToken() (*token.Token, error) {    
     for lex.CurrentChar != 0 {
+        start := lex.Position
         switch {
+        case lex.CurrentChar == '\n' || lex.CurrentChar == ';':
+            // both end a statement, the value tells them apart
+            separator := rune(lex.CurrentChar)
+            lex.GetNextChar()
+            return lex.newToken(SEMI, separator, start), nil
+
         case unicode.IsSpace(rune(lex.CurrentChar)):
             lex.SkipWhiteSpace()
 
+        case lex.CurrentChar == '#':
+            lex.SkipComment()
+
         case unicode.IsDigit(rune(lex.CurrentChar)):
             integer, err := lex.Integer()
             if err != nil {
                 return token.NewToken("",0), err
             }
-            return token.NewToken(INTEGER, integer), nil
+            // a decimal integer followed by '.' and a digit is the integer part of a decimal literal (1..5 is a range)
+            literal := lex.Input[start:lex.Position]
+            prefixed := len(literal) > 1 && literal[0] == '0' && bases[literal[1]] != 0
+            var number interface{} = integer
+            if !prefixed && lex.CurrentChar == '.' && unicode.IsDigit(rune(lex.Peek())) {
+                if number, err = lex.Fraction(start); err != nil {
+                    return token.NewToken("",0), err
+                }
+            }
+            // a decimal number followed by an i that does not start a word is imaginary: 2i, 0.5i (2in is not)
+            if !prefixed && lex.CurrentChar == 'i' && !isLetter(lex.Peek()) && !unicode.IsDigit(rune(lex.Peek())) {
+                lex.GetNextChar()
+                if i, ok := number.(int); ok {
+                    number = float64(i)
+                }
+                return lex.newToken(IMAGINARY, number, start), nil
+            }
+            if f, ok := number.(float64); ok {
+                return lex.newToken(FLOAT, f, start), nil
+            }
+            return lex.newToken(INTEGER, integer, start), nil
+
+        case isLetter(lex.CurrentChar):
+            word := lex.Word()
+            keyword, ok := keywords[word]
+            if !ok {
+                return lex.newToken(IDENT, word, start), nil // not a keyword: a variable name
+            }
+            return lex.newToken(keyword.TokenType, keyword.Value, start), nil
+
+        case lex.CurrentChar == '=' && lex.Peek() == '=':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(EQ, "==", start), nil
+
+        case lex.CurrentChar == '=':
+            lex.GetNextChar()
+            return lex.newToken(ASSIGN, '=', start), nil
+
+        case lex.CurrentChar == '.' && lex.Peek() == '.':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(DOTDOT, "..", start), nil
+
+        case lex.CurrentChar == '.' && lex.Peek() == '*':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(DOTMUL, ".*", start), nil
+
+        case lex.CurrentChar == '!' && lex.Peek() == '=':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(NE, "!=", start), nil
+
+        case lex.CurrentChar == '!':
+            lex.GetNextChar()
+            return lex.newToken(NOT, '!', start), nil
+
+        case lex.CurrentChar == '<' && lex.Peek() == '<':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(SHL, "<<", start), nil
+
+        case lex.CurrentChar == '>' && lex.Peek() == '>':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(SHR, ">>", start), nil
+
+        case lex.CurrentChar == '<' && lex.Peek() == '=':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(LE, "<=", start), nil
+
+        case lex.CurrentChar == '<':
+            lex.GetNextChar()
+            return lex.newToken(LT, '<', start), nil
+
+        case lex.CurrentChar == '>' && lex.Peek() == '=':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(GE, ">=", start), nil
+
+        case lex.CurrentChar == '>':
+            lex.GetNextChar()
+            return lex.newToken(GT, '>', start), nil
+
+        case lex.CurrentChar == '&' && lex.Peek() == '&':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(AND, "&&", start), nil
+
+        case lex.CurrentChar == '|' && lex.Peek() == '|':
+            lex.GetNextChar()
+            lex.GetNextChar()
+            return lex.newToken(OR, "||", start), nil
+
+        case lex.CurrentChar == '{':
+            lex.GetNextChar()
+            return lex.newToken(LBRACE, '{', start), nil
+
+        case lex.CurrentChar == '}':
+            lex.GetNextChar()
+            return lex.newToken(RBRACE, '}', start), nil
+
+        case lex.CurrentChar == '?':
+            lex.GetNextChar()
+            return lex.newToken(QUESTION, '?', start), nil
+
+        case lex.CurrentChar == ':':
+            lex.GetNextChar()
+            return lex.newToken(COLON, ':', start), nil
+
+        case lex.CurrentChar == '&':
+            lex.GetNextChar()
+            return lex.newToken(BITAND, '&', start), nil
+
+        case lex.CurrentChar == '|':
+            lex.GetNextChar()
+            return lex.newToken(BITOR, '|', start), nil
+
+        case lex.CurrentChar == '^':
+            lex.GetNextChar()
+            return lex.newToken(CARET, '^', start), nil
+
+        case lex.CurrentChar == '~':
+            lex.GetNextChar()
+            return lex.newToken(TILDE, '~', start), nil
+
+        case lex.CurrentChar == '$':
+            // $n refers to the n-th result of the session 
+            lex.GetNextChar()
+            if !unicode.IsDigit(rune(lex.CurrentChar)) {
+                return token.NewToken("",0), fmt.Errorf("lexer.GetNextToken(): expected a result number after $")
+            }
+            number := lex.Word()
+            index, err := strconv.Atoi(number)
+            if err != nil {
+                return token.NewToken("",0), fmt.Errorf("lexer.GetNextToken(): malformed result reference: $%s", number)
+            }
+            return lex.newToken(HISTORY, index, start), nil
+
+        case lex.CurrentChar == ',':
+            lex.GetNextChar()
+            return lex.newToken(COMMA, ',', start), nil
+
+        case lex.CurrentChar == '%':
+            lex.GetNextChar()
+            return lex.newToken(PERCENT, '%', start), nil
 
         case lex.CurrentChar == '+':
             lex.GetNextChar()
-            return token.NewToken(PLUS, '+'), nil
+            return lex.newToken(PLUS, '+', start), nil
         
         case lex.CurrentChar == '-':
             lex.GetNextChar()
-            //fmt.Printf("lexer.GetNextToken(): returning MINUS token\n")
-            return token.NewToken(MINUS, '-'), nil
+            return lex.newToken(MINUS, '-', start), nil
 
         case lex.CurrentChar == '*':
             lex.GetNextChar()
-            //fmt.Printf("lexer.GetNextToken(): (MUL, '*')\n")
-            return token.NewToken(MUL, '-'), nil
+            return lex.newToken(MUL, '*', start), nil
             
         case lex.CurrentChar == '/':
             lex.GetNextChar()
-            return token.NewToken(DIV, '/'), nil
+            return lex.newToken(DIV, '/', start), nil
 
         case lex.CurrentChar == '(':
             lex.GetNextChar()
-            //fmt.Printf("lexer.GetNextToken(): (LPAR, '(')\n")
-            return token.NewToken(LPAR, '('), nil
+            return lex.newToken(LPAR, '(', start), nil
             
         case lex.CurrentChar == ')':
             lex.GetNextChar()
-            //fmt.Printf("lexer.GetNextToken(): (RPAR, ')')\n")
-            return token.NewToken(RPAR, ')'), nil
+            return lex.newToken(RPAR, ')', start), nil
+
+        case lex.CurrentChar == '[':
+            lex.GetNextChar()
+            return lex.newToken(LBRACKET, '[', start), nil
+
+        case lex.CurrentChar == ']':
+            lex.GetNextChar()
+            return lex.newToken(RBRACKET, ']', start), nil
 
         default:
             return token.NewToken("",0), fmt.Errorf("lexer.GetNextToken(): invalid character: %c", lex.CurrentChar)
          }
      }
-    //fmt.Printf("lexer.GetNextToken(): returning EOF token\n")
-    return token.NewToken(EOF, 0), nil
+    return lex.newToken(EOF, 0, lex.Position), nil
+}
+
+// newToken creates a token that starts at the given position in the input and ends at the current 
+// position, keeping its original spelling as the token's literal
+func (lex *Lexer) newToken(tokenType string, value interface{}, position int) *token.Token {
+    t := token.NewToken(tokenType, value)
+    t.Position = position
+    if position < len(lex.Input) {
+        t.Literal = lex.Input[position:lex.Position]
+    }
+    return t
 }
@@ -1,7 +1,7 @@
 /* 
 
 Calculator which takes an arithmetic expression as input, evaluates it, and provides the result as output.
-Accepts '+', '-', '*', '/', '(', ')', and integer strings. If input includes a character not in the 
+Accepts '+', '-', '*', '/', '(', ')', integer strings, comparisons and boolean operators. If input includes a character not in the 
 alphabet of the interpreter, an error is returned indicating unrecognized character was in the input. 
 If input includes a structural syntax error, a syntax error is returned. Otherwise, the result is returned.
 */
@@ -9,29 +9,412 @@ If input includes a structural syntax error, a syntax error is returned. Otherwi
 package main
 
 import (
+    "calculator/ast"
+    "calculator/calculus"
+    "calculator/format"
     "calculator/interpreter"
     "calculator/lexer"
     "calculator/parser"
     "fmt"
+    "flag"
     "os"
     "bufio"
+    "strings"
 
 )
 
+var (
+    overflowFlag = flag.String("overflow", "error", "integer overflow behaviour: error, wrap or saturate")
+    wordFlag     = flag.String("word", "", "programmer mode word size: int8, int16, int32, int64, uint8 ... uint64")
+    baseFlag     = flag.String("base", "10", "base integer results are printed in: 2, 8, 10 or 16")
+    placesFlag   = flag.String("places", "auto", "number of decimal places results are printed with")
+    sigfigsFlag  = flag.String("sigfigs", "auto", "number of significant figures results are printed with")
+    groupFlag    = flag.Bool("group", false, "group digits in thousands")
+    notationFlag = flag.String("notation", "plain", "notation results are printed in: plain, sci or eng")
+    implicitFlag = flag.Bool("implicit", false, "multiply juxtaposed factors: 2(3+4), (1+2)(3+4), 2x")
+    traceFlag    = flag.Bool("trace", false, "show each step of every evaluation")
+    rationalFlag = flag.Bool("rational", false, "compute the statistics functions exactly with rational arithmetic")
+    complexFlag  = flag.Bool("complex", false, "give sqrt(-1) and the like their complex value instead of an error")
+    loadFlag     = flag.String("load", "", "session file to load on start, see :save")
+    configFlag   = flag.String("config", "", "configuration file read on start (default: calculator/config in the user config directory, none to skip)")
+)
+
 
 
 
-// errorTest checks if there was a scanning error 
+// errorTest checks if there was a scanning or start up error 
 func errorTest(err error) {
     if err != nil {
-        fmt.Printf("Error reading input: %v\n", err)
+        fmt.Printf("Error: %v\n", err)
         os.Exit(1)
     }
 }
 
 
 
+// session holds what is kept from one evaluation to the next: the settings chosen on the command line or 
+// with :set, the variables and functions defined so far and the numbered results ($1, $2, ...)
+type session struct {
+    overflow  interpreter.OverflowMode
+    word      *interpreter.WordSize // nil unless in programmer mode
+    implicit  bool                  // implicit multiplication
+    trace     bool                  // show the steps of each evaluation
+    rational  bool                  // exact statistics
+    complex   bool                  // complex results for real arguments
+    format    format.Options
+    variables map[string]interface{}
+    functions map[string]*ast.FunctionDefinition
+    history   []interface{}
+}
+
+var onOff = map[bool]string{true: "on", false: "off"}
+
+// newSession creates a session from the configuration file and the command line flags, which override the
+// settings of the file 
+func newSession() (*session, error) {
+    s := &session{format: format.Default()}
+    s.clear()
+    if err := s.configure(*configFlag); err != nil {
+        return nil, err
+    }
+    given := make(map[string]bool)
+    flag.Visit(func(f *flag.Flag) {
+        given[f.Name] = true
+    })
+    settings := [][2]string{
+        {"overflow", *overflowFlag},
+        {"word", *wordFlag},
+        {"base", *baseFlag},
+        {"places", *placesFlag},
+        {"sigfigs", *sigfigsFlag},
+        {"group", onOff[*groupFlag]},
+        {"notation", *notationFlag},
+        {"implicit", onOff[*implicitFlag]},
+        {"trace", onOff[*traceFlag]},
+        {"rational", onOff[*rationalFlag]},
+        {"complex", onOff[*complexFlag]},
+    }
+    for _, setting := range settings {
+        if !given[setting[0]] {
+            continue
+        }
+        if err := s.set(setting[0], setting[1]); err != nil {
+            return nil, err
+        }
+    }
+    if *loadFlag != "" {
+        if err := s.load(*loadFlag); err != nil {
+            return nil, err
+        }
+    }
+    return s, nil
+}
+
+// clear forgets the variables, functions and results of the session, keeping its settings 
+func (s *session) clear() {
+    s.variables = make(map[string]interface{})
+    s.functions = make(map[string]*ast.FunctionDefinition)
+    s.history = nil
+}
+
+// set changes one of the session's settings: overflow, word (a word size, or "off" to leave programmer 
+// mode), implicit, trace, rational or complex (on or off) or one of the format options
+func (s *session) set(name, value string) error {
+    var err error
+    switch name {
+    case "overflow":
+        s.overflow, err = interpreter.ParseOverflowMode(value)
+    case "word":
+        s.word = nil
+        if value != "" && value != "off" {
+            s.word, err = interpreter.ParseWordSize(value)
+        }
+        s.format.Word = s.word
+    case "implicit", "trace", "rational", "complex":
+        if value != "on" && value != "off" {
+            return fmt.Errorf("%s must be on or off, not %s", name, value)
+        }
+        switch name {
+        case "implicit":
+            s.implicit = value == "on"
+        case "trace":
+            s.trace = value == "on"
+        case "rational":
+            s.rational = value == "on"
+        default:
+            s.complex = value == "on"
+        }
+    default:
+        err = s.format.Set(name, value)
+    }
+    return err
+}
+
+// settings returns the session's settings as name=value pairs that set accepts
+func (s *session) settings() []string {
+    word := "off"
+    if s.word != nil {
+        word = s.word.Name
+    }
+    return append(s.format.Settings(), "overflow=" + s.overflow.String(), "word=" + word,
+        "implicit=" + onOff[s.implicit], "trace=" + onOff[s.trace], "rational=" + onOff[s.rational],
+        "complex=" + onOff[s.complex])
+}
+
+// command runs a REPL command, a line starting with ':'. ":set name value" changes a setting and ":set" 
+// on its own lists them. ":history" lists the numbered results and ":constants" the named constants. 
+// ":trace input" evaluates the input showing each step. ":save file" and ":load file" write the session to
+// a file and read it back. 
+func (s *session) command(line string) error {
+    fields := strings.Fields(line)
+    switch {
+    case (fields[0] == ":save" || fields[0] == ":load") && len(fields) > 1:
+        path := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+        if fields[0] == ":save" {
+            return s.save(path)
+        }
+        return s.load(path)
+    case fields[0] == ":save" || fields[0] == ":load":
+        return fmt.Errorf("usage: %s file", fields[0])
+    case fields[0] == ":trace" && len(fields) > 1:
+        interp, err := s.interpreter(strings.TrimPrefix(strings.TrimSpace(line), ":trace"))
+        if err != nil {
+            return err
+        }
+        result, err := s.traced(interp)
+        if err != nil {
+            return err
+        }
+        s.printResult(result)
+        return nil
+    case fields[0] == ":trace":
+        return fmt.Errorf("usage: :trace expression")
+    case fields[0] == ":constants":
+        for _, constant := range interpreter.Constants() {
+            fmt.Printf("%-5s = %-22v %-13s %s\n", constant.Name, constant.Value, constant.Unit, constant.Description)
+        }
+        return nil
+    case fields[0] == ":history":
+        for i, result := range s.history {
+            fmt.Printf("$%d = %s\n", i + 1, format.Value(result, s.format))
+        }
+        return nil
+    case fields[0] == ":set" && len(fields) == 1:
+        fmt.Println(strings.Join(s.settings(), " "))
+        return nil
+    case fields[0] == ":set" && len(fields) == 3:
+        return s.set(fields[1], fields[2])
+    case fields[0] == ":set":
+        return fmt.Errorf("usage: :set name value")
+    default:
+        return fmt.Errorf("unknown command: %s", fields[0])
+    }
+}
+
+// evaluate runs the input (one or more statements) through the lexer, parser and interpreter, showing each
+// step if tracing is on. Variables assigned by the input are kept in the session. For an input that makes i
+// a variable, such as i = 2, note is imaginaryNote, since i hides the imaginary unit from then on; it is ""
+// otherwise. 
+func (s *session) evaluate(input string) (result interface{}, note string, err error) {
+    _, hidden := s.variables["i"]
+    defer func() {
+        if _, ok := s.variables["i"]; ok && !hidden {
+            note = imaginaryNote
+        }
+    }()
+    interp, err := s.interpreter(input)
+    if err != nil {
+        return nil, "", err
+    }
+    if s.trace {
+        result, err = s.traced(interp)
+        return result, "", err
+    }
+    result, err = interp.Evaluate()
+    return result, "", err
+}
+
+// imaginaryNote is printed when an input makes i a variable
+const imaginaryNote = "note: i is now a variable and hides the imaginary unit, write 1i for the unit"
+
+// calculus handles the calls that work on the expression given as their argument rather than its value: 
+// diff(expression, variable) returns the derivative, as infix text, and solve(left = right, variable) or 
+// solve(left = right, variable, low, high) the roots of the equation. ok is false for any other input, which
+// is evaluated as usual. 
+func (s *session) calculus(input string) (text string, ok bool, err error) {
+    interp, err := s.interpreter(input)
+    if err != nil {
+        return "", false, nil // reported when the input is evaluated 
+    }
+    root, err := interp.Parser.Parse()
+    if err != nil {
+        return "", false, nil
+    }
+    statements := root.(*ast.Block).Statements
+    if len(statements) != 1 {
+        return "", false, nil
+    }
+    call, ok := statements[0].(*ast.Call)
+    if !ok || call.Name != "diff" && call.Name != "solve" {
+        return "", false, nil
+    }
+    if call.Name == "solve" {
+        if len(call.Arguments) == 1 {
+            if block, ok := call.Arguments[0].(*ast.Block); ok {
+                text, err := s.solveSystem(interp, block)
+                return text, true, err
+            }
+        }
+        text, err := s.solve(interp, call)
+        return text, true, err
+    }
+    if len(call.Arguments) != 2 {
+        return "", true, fmt.Errorf("usage: diff(expression, variable)")
+    }
+    variable, ok := call.Arguments[1].(*ast.Variable)
+    if !ok {
+        return "", true, fmt.Errorf("diff: %s is not a variable", interpreter.Source(call.Arguments[1]))
+    }
+    derivative, err := calculus.Derivative(call.Arguments[0], variable.Name)
+    if err != nil {
+        return "", true, err
+    }
+    return interpreter.Source(derivative), true, nil
+}
+
+// solve solves the equation of a solve() call and writes the roots as "x = 1, x = 2"
+func (s *session) solve(interp *interpreter.Interpreter, call *ast.Call) (string, error) {
+    if len(call.Arguments) != 2 && len(call.Arguments) != 4 {
+        return "", fmt.Errorf("usage: solve(left = right, variable) or solve(left = right, variable, low, high)")
+    }
+    equation, ok := call.Arguments[0].(*ast.Equation)
+    if !ok {
+        return "", fmt.Errorf("solve: %s is not an equation", interpreter.Source(call.Arguments[0]))
+    }
+    variable, ok := call.Arguments[1].(*ast.Variable)
+    if !ok {
+        return "", fmt.Errorf("solve: %s is not a variable", interpreter.Source(call.Arguments[1]))
+    }
+    bounds := []float64{calculus.DefaultLow, calculus.DefaultHigh}
+    for i, argument := range call.Arguments[2:] {
+        value, err := interp.EvaluateNode(argument)
+        if err != nil {
+            return "", err
+        }
+        switch v := value.(type) {
+        case int:
+            bounds[i] = float64(v)
+        case float64:
+            bounds[i] = v
+        default:
+            return "", fmt.Errorf("solve: the bounds of the interval must be numbers, not %s", interpreter.Source(argument))
+        }
+    }
+    roots, err := calculus.Solve(interp, equation, variable.Name, bounds[0], bounds[1])
+    if err != nil && len(roots) == 0 {
+        return "", err
+    }
+    if len(roots) == 0 {
+        return fmt.Sprintf("no solution for %s in [%v, %v]", variable.Name, bounds[0], bounds[1]), nil
+    }
+    solutions := make([]string, len(roots))
+    for i, root := range roots {
+        solutions[i] = variable.Name + " = " + format.Value(root, s.format)
+    }
+    if err != nil {
+        // Newton's method failed somewhere else, there may be a root the roots found leave out
+        return strings.Join(solutions, ", ") + " (" + err.Error() + ")", nil
+    }
+    return strings.Join(solutions, ", "), nil
+}
+
+// solveSystem solves the linear system of a solve {...} call and writes the solution as "x = 2, y = 1",
+// exactly (x = 1/3) unless a float was involved
+func (s *session) solveSystem(interp *interpreter.Interpreter, block *ast.Block) (string, error) {
+    equations := make([]*ast.Equation, len(block.Statements))
+    for i, statement := range block.Statements {
+        equations[i] = statement.(*ast.Equation) // the parser only puts equations in a solve {...}
+    }
+    solution, err := calculus.SolveLinear(interp, equations)
+    if err != nil {
+        return "", err
+    }
+    values := make([]string, len(solution.Names))
+    for i, name := range solution.Names {
+        if solution.Exact {
+            values[i] = name + " = " + solution.Values[i].RatString()
+        } else {
+            value, _ := solution.Values[i].Float64()
+            values[i] = name + " = " + format.Value(value, s.format)
+        }
+    }
+    return strings.Join(values, ", "), nil
+}
+
+// traced evaluates with a tracer and prints the steps, also when evaluation stopped with an error 
+func (s *session) traced(interp *interpreter.Interpreter) (interface{}, error) {
+    tracer := interpreter.NewTracer(interp)
+    tracer.Format = func(value interface{}) string {
+        return format.Value(value, s.format)
+    }
+    result, err := tracer.Evaluate()
+    for _, line := range tracer.Lines() {
+        fmt.Println(line)
+    }
+    return result, err
+}
+
+// interpreter creates an interpreter for the input with the session's settings, variables and results
+func (s *session) interpreter(input string) (*interpreter.Interpreter, error) {
+    lexer := lexer.NewLexer(input)
+
+    parser, err := parser.NewParser(lexer)
+    if err != nil {
+        return nil, err
+    }
+    parser.Programmer = s.word != nil
+    parser.ImplicitMul = s.implicit
+    interp := interpreter.NewInterpreter(parser)
+    interp.Overflow = s.overflow
+    interp.Word = s.word
+    interp.Rational = s.rational
+    interp.Complex = s.complex
+    interp.Variables = s.variables
+    interp.History = s.history
+    interp.Functions = s.functions
+    return interp, nil
+}
+
+// printResult prints the value of the last statement with the session's format options and its number in
+// the history. Statements such as a loop that never ran have no value and are not numbered. 
+func (s *session) printResult(result interface{}) {
+    if result != nil {
+        s.history = append(s.history, result)
+        fmt.Printf("result $%d: %s\n", len(s.history), format.Value(result, s.format))
+    }
+}
+
+// runFile evaluates a whole program file and prints the value of its last statement 
+func (s *session) runFile(path string) {
+    input, err := os.ReadFile(path)
+    errorTest(err)
+    result, note, err := s.evaluate(string(input))
+    if note != "" {
+        fmt.Println(note)
+    }
+    errorTest(err)
+    s.printResult(result)
+}
+
 func main() {
+    flag.Parse()
+    session, err := newSession()
+    errorTest(err)
+    if flag.NArg() > 0 {
+        session.runFile(flag.Arg(0))
+        return
+    }
+
     scanner := bufio.NewScanner(os.Stdin)
     fmt.Println("-------------------------------------\n... Starting calculator... (Q = exit)")
     for {
@@ -46,22 +429,30 @@ func main() {
         if input == "q" || input == "Q" {
             break
         }
+        if strings.HasPrefix(input, ":") {
+            if err := session.command(input); err != nil {
+                fmt.Printf("%v\n", err)
+            }
+            continue
+        }
  
-        lexer := lexer.NewLexer(input)
-
-        parser, err := parser.NewParser(lexer)
-        if err != nil {
-            fmt.Printf("%v\n",err)
+        if text, ok, err := session.calculus(input); ok {
+            if err != nil {
+                fmt.Printf("%v\n", err)
+            } else {
+                fmt.Printf("result: %s\n", text)
+            }
             continue
         }
-        interp := interpreter.NewInterpreter(parser)
-
-        result, err1 := interp.Interpret()
+        result, note, err1 := session.evaluate(input)
+        if note != "" {
+            fmt.Println(note)
+        }
         if err1 != nil {
             fmt.Printf("%v\n",err1)
             continue
         }
-        fmt.Printf("result: %v\n",result)
+        session.printResult(result)
     }
     errorTest(scanner.Err())
 }
@@ -1,9 +1,20 @@
 package main 
 
 import (
+    "calculator/ast"
+    "calculator/calculus"
+    "calculator/format"
     "calculator/lexer"
+    "calculator/nestingstack"
+    "calculator/units"
     "calculator/parser"
     "calculator/interpreter"
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "os"
+    "strings"
     "testing"
 )
 
@@ -133,3 +144,1619 @@ func TestInterpreter(t *testing.T) {
         }
 */ 
 }
+
+
+// newInterpreter builds the lexer -> parser -> interpreter pipeline for a single input
+func newInterpreter(input string) *interpreter.Interpreter {
+    lexer := lexer.NewLexer(input)
+    parser, _ := parser.NewParser(lexer)
+    return interpreter.NewInterpreter(parser)
+}
+
+// checkResult reports an evaluation that failed when it should have passed (or vice versa), or that passed
+// with the wrong result
+func checkResult(t *testing.T, input string, shouldPass bool, expectedResult, result interface{}, err error) {
+    t.Helper()
+    if err == nil && !shouldPass {
+        t.Errorf("FAIL: no error returned from invalid input: %s: output: %v", input, result)
+    }
+    if err != nil && shouldPass {
+        t.Errorf("FAIL: error returned from valid input: %s: error message: %v", input, err)
+    }
+    if err == nil && shouldPass && result != expectedResult {
+        t.Errorf("FAIL: incorrect result on input: %s: expected result: %v: actual result: %v",
+            input, expectedResult, result)
+    }
+}
+
+func TestInterpreterLimits(t *testing.T) {
+    testCases := []struct {
+        input      string
+        limits     interpreter.Limits
+        shouldPass bool
+    }{
+        {"1 + 2 * 3", interpreter.Limits{}, true},                      // no limits set
+        {"1 + 2 * 3", interpreter.Limits{MaxNodes: 5}, true},           // exactly 5 nodes
+        {"1 + 2 * 3", interpreter.Limits{MaxNodes: 4}, false},          // too many nodes
+        {"1 + 2 * 3", interpreter.Limits{MaxSteps: 5}, true},           // exactly 5 visits
+        {"1 + 2 * 3", interpreter.Limits{MaxSteps: 4}, false},          // too many visits
+        {"((((1))))", interpreter.Limits{MaxDepth: 1}, true},           // parentheses don't add nodes
+        {"- - - 1", interpreter.Limits{MaxDepth: 3}, false},            // 4 levels deep
+        {"- - - 1", interpreter.Limits{MaxDepth: 4}, true},
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Limits = testCase.limits
+        result, err := interp.Interpret()
+        if err == nil && !testCase.shouldPass {
+            t.Errorf("FAIL: no error returned with limits %+v: %s: output: %d", testCase.limits, testCase.input, result)
+        }
+        if err != nil && testCase.shouldPass {
+            t.Errorf("FAIL: error returned with limits %+v: %s: error message: %v", testCase.limits, testCase.input, err)
+        }
+        if err != nil && !testCase.shouldPass && !errors.Is(err, interpreter.ErrLimitExceeded) {
+            t.Errorf("FAIL: expected limit error on input: %s: error message: %v", testCase.input, err)
+        }
+    }
+
+    // the default limits stop a function that never returns, but not a long expression
+    for _, input := range []string{"f(x) = f(x); f(1)", "f(n) = 1 + f(n + 1); f(1)"} {
+        _, err := newInterpreter(input).Evaluate()
+        var limitError *interpreter.LimitError
+        if !errors.As(err, &limitError) || limitError.Limit != "recursion" {
+            t.Errorf("FAIL: expected the recursion limit on input: %s: got %v", input, err)
+        }
+    }
+    sum := "1" + strings.Repeat(" + 1", 10000)
+    if result, err := newInterpreter(sum).Evaluate(); err != nil || result != 10001 {
+        t.Errorf("FAIL: a sum of 10001 ones: expected 10001, got %v, %v", result, err)
+    }
+    if result, err := newInterpreter("f(n) = n == 1 ? 1 : 1 + f(n - 1); f(1000)").Evaluate(); err != nil || result != 1000 {
+        t.Errorf("FAIL: 1000 nested calls: expected 1000, got %v, %v", result, err)
+    }
+}
+
+func TestInterpreterCancellation(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    _, err := newInterpreter("1 + 2").InterpretContext(ctx)
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("FAIL: expected cancellation error: got %v", err)
+    }
+    result, err := newInterpreter("1 + 2").InterpretContext(context.Background())
+    if err != nil || result != 3 {
+        t.Errorf("FAIL: InterpretContext(): expected 3: got %d, %v", result, err)
+    }
+}
+
+func TestOverflow(t *testing.T) {
+    testCases := []struct {
+        input          string
+        mode           interpreter.OverflowMode
+        shouldPass     bool
+        expectedResult int
+    }{
+        {"2147483647 * 2147483647", interpreter.OverflowCheck, false, 0},
+        {"2147483647 + 1", interpreter.OverflowCheck, false, 0},
+        {"-2147483647 - 2", interpreter.OverflowCheck, false, 0},
+        {"(-2147483647 - 1) / -1", interpreter.OverflowCheck, false, 0},  // MinInt / -1
+        {"-(-2147483647 - 1)", interpreter.OverflowCheck, false, 0},      // -MinInt
+        {"2147483647 - 1 + 1", interpreter.OverflowCheck, true, 2147483647},
+        {"-2147483647 - 1", interpreter.OverflowCheck, true, -2147483648},
+        {"2147483647 + 1", interpreter.OverflowWrap, true, -2147483648},
+        {"65536 * 65536", interpreter.OverflowWrap, true, 0},
+        {"2147483647 * 2", interpreter.OverflowSaturate, true, 2147483647},
+        {"-2147483647 * 2", interpreter.OverflowSaturate, true, -2147483648},
+        {"4294967296", interpreter.OverflowWrap, false, 0},                // literals are never wrapped
+        {"2147483648", interpreter.OverflowSaturate, false, 0},            // or saturated
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Overflow = testCase.mode
+        result, err := interp.Interpret()
+        if err == nil && !testCase.shouldPass {
+            t.Errorf("FAIL: no error returned on overflow: %s: output: %d", testCase.input, result)
+        }
+        if err != nil && testCase.shouldPass {
+            t.Errorf("FAIL: error returned from valid input: %s: error message: %v", testCase.input, err)
+        }
+        if err != nil && !testCase.shouldPass && !errors.Is(err, interpreter.ErrOverflow) {
+            t.Errorf("FAIL: expected overflow error on input: %s: error message: %v", testCase.input, err)
+        }
+        if err == nil && testCase.shouldPass && result != testCase.expectedResult {
+            t.Errorf("FAIL: incorrect result on input: %s: expected result: %d: actual result: %d",
+                testCase.input, testCase.expectedResult, result)
+        }
+    }
+
+    _, err := newInterpreter("1 + 2147483647 * 2").Interpret()
+    if err == nil || err.Error() != "interpreter: integer overflow: 2147483647 * 2 at column 16" {
+        t.Errorf("FAIL: overflow error should name the operation and its position: got %v", err)
+    }
+}
+
+func TestBooleans(t *testing.T) {
+    testCases := []struct {
+        input          string
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"1 < 2", true, true},
+        {"2 <= 2", true, true},
+        {"3 > 4", true, false},
+        {"3 >= 4", true, false},
+        {"1 + 1 == 2", true, true},                      // arithmetic binds tighter than comparison
+        {"1 != 1", true, false},
+        {"true == false", true, false},
+        {"!true", true, false},
+        {"!(1 > 2)", true, true},
+        {"true || false && false", true, true},           // && binds tighter than ||
+        {"(true || false) && false", true, false},
+        {"1 < 2 == true", true, true},                    // relational binds tighter than equality
+        {"age >= 18", false, nil},                        // undefined variable
+        {"false && 1 / 0 == 1", true, false},             // right side never evaluated
+        {"true || 1 / 0 == 1", true, true},
+        {"true && 1 / 0 == 1", false, nil},               // right side evaluated: division by zero
+        {"true + 1", false, nil},                         // type errors
+        {"-true", false, nil},
+        {"!1", false, nil},
+        {"1 && true", false, nil},
+        {"true && 1", false, nil},
+        {"true < false", false, nil},
+        {"1 == true", false, nil},
+        {"1 < ", false, nil},
+        {"1 = 1", false, nil},
+        {"1 & true", false, nil},
+    }
+
+    for _, testCase := range testCases {
+        result, err := newInterpreter(testCase.input).Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+}
+
+func TestVariables(t *testing.T) {
+    variables := map[string]interface{}{"age": 21, "score": 650, "member": true}
+    testCases := []struct {
+        input          string
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"age >= 18 && score > 700", true, false},
+        {"age >= 18 && (score > 700 || member)", true, true},
+        {"age * 2", true, 42},
+        {"member + 1", false, nil},
+        {"height > 180", false, nil},
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Variables = variables
+        result, err := interp.Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+}
+
+func TestConditionals(t *testing.T) {
+    variables := map[string]interface{}{"x": 0, "y": 4}
+    testCases := []struct {
+        input          string
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"x == 0 ? 0 : 1 / x", true, 0},                      // untaken branch never divides by zero
+        {"y == 0 ? 0 : 8 / y", true, 2},
+        {"if x == 0 then 0 else 1 / x", true, 0},
+        {"if y > 2 then y * 10 else y", true, 40},
+        {"x > 0 ? 1 : x < 0 ? -1 : 0", true, 0},              // right associative
+        {"y > 0 ? 1 : y < 0 ? -1 : 0", true, 1},
+        {"2 + (y > 3 ? 10 : 20) * 2", true, 22},
+        {"2 + if y > 3 then 10 else 20", true, 12},           // the else branch extends as far as possible
+        {"if true then false else true", true, false},
+        {"1 ? 2 : 3", false, nil},                            // condition must be a boolean
+        {"if 1 then 2 else 3", false, nil},
+        {"true ? 2", false, nil},                             // missing :
+        {"if true then 2", false, nil},                       // missing else
+        {"if true 2 else 3", false, nil},                     // missing then
+        {"true ? : 3", false, nil},
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Variables = variables
+        result, err := interp.Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+}
+
+func TestPrograms(t *testing.T) {
+    testCases := []struct {
+        input          string
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"1; 2; 3", true, 3},                                 // value of the last statement
+        {"1\n2 * 3\n", true, 6},
+        {";;1;;", true, 1},                                   // empty statements are ignored
+        {"# the answer\n42 # after the statement", true, 42},
+        {"# only a comment", false, nil},                     // no statements at all
+        {"(1 +\n 2) * 3", true, 9},                           // newlines inside parentheses are white space
+        {"1 +\n 2", false, nil},                              // but end a statement outside of them
+        {"{1; 2} + 3", true, 5},
+        {"{\n  1\n  2 * 4\n}", true, 8},
+        {"({1\n2}) * 2", true, 4},
+        {"true ? {1; 2} : 3", true, 2},
+        {"{}", false, nil},                                   // empty block has no value
+        {"{1; 2", false, nil},                                // missing }
+        {"1; 2}", false, nil},
+        {"(1; 2)", false, nil},                               // ; is not allowed inside parentheses
+        {"{1) }", false, nil},
+        {"1 2; 3", false, nil},
+        {"1; 1 / 0; 3", false, nil},                          // every statement is evaluated
+    }
+
+    for _, testCase := range testCases {
+        result, err := newInterpreter(testCase.input).Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+}
+
+func TestLoops(t *testing.T) {
+    testCases := []struct {
+        input          string
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"x = 5; x * 2", true, 10},                                          // assignment
+        {"x = y = 1", false, nil},                                           // assignment is a statement
+        {"1 + 2 = 3", false, nil},                                           // not a variable
+        {"total = 0; for i in 1..10 { total = total + i }; total", true, 55},
+        {"n = 0; for i in 3..1 { n = n + 1 }; n", true, 0},                  // empty range
+        {"for i in 1..3 { i * 10 }", true, 30},                              // value of the last iteration
+        {"i = 7; for i in 1..3 { }; i", true, 7},                            // the variable only exists in the loop
+        {"for n in 1..3 { }; n", false, nil},
+        {"x = 1; while x < 100 { x = x * 3 }; x", true, 243},
+        {"x = 1; while x < 100 { x = x * 3 }", true, 243},
+        {"n = 0; while true { n = n + 1; n == 7 ? { break } : 0 }; n", true, 7},
+        {"for i in 1..10 { i == 4 ? { break } : 0; i }", true, 3},           // value before break
+        {"s = 0; for i in 1..3 { for j in 1..10 { j > i ? { break } : 0; s = s + j } }; s", true, 10},
+        {"while 1 { }", false, nil},                                         // condition must be a boolean
+        {"for i in true..3 { }", false, nil},
+        {"break", false, nil},                                               // outside of a loop
+        {"{ break }", false, nil},
+        {"while true x = 1", false, nil},                                    // body needs braces
+        {"for 1 in 1..2 { }", false, nil},
+        {"for i 1..2 { }", false, nil},
+        {"while true { }", false, nil},                                      // iteration limit
+        {"x = 0; while x < 5 { x = x + 1; y }", false, nil},                 // undefined variable
+    }
+
+    for _, testCase := range testCases {
+        result, err := newInterpreter(testCase.input).Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+
+    interp := newInterpreter("n = 0; while true { n = n + 1 }")
+    interp.Limits.MaxIterations = 10
+    _, err := interp.Evaluate()
+    if !errors.Is(err, interpreter.ErrLimitExceeded) || interp.Variables["n"] != 10 {
+        t.Errorf("FAIL: expected iteration limit after 10 iterations: got %v, n = %v", err, interp.Variables["n"])
+    }
+}
+
+func TestIntegerLiterals(t *testing.T) {
+    testCases := []struct {
+        input          string
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"0xFF", true, 255},
+        {"0Xff + 1", true, 256},
+        {"0b1010", true, 10},
+        {"0o17", true, 15},
+        {"0O777", true, 511},
+        {"1_000_000", true, 1000000},
+        {"0xFF_FF", true, 65535},
+        {"0b1111_0000", true, 240},
+        {"007", true, 7},                    // leading zeros are still decimal
+        {"0", true, 0},
+        {"0x7FFF_FFFF", true, 2147483647},
+        {"0x8000_0000", false, nil},         // out of 32-bit range
+        {"0b102", false, nil},               // invalid digit
+        {"0o8", false, nil},
+        {"0xFG", false, nil},
+        {"0x", false, nil},                  // missing digits
+        {"0b", false, nil},
+        {"1__000", false, nil},              // separators must separate digits
+        {"1000_", false, nil},
+        {"0x_FF", false, nil},
+        {"_1000", false, nil},               // a variable name, which is undefined
+    }
+
+    for _, testCase := range testCases {
+        result, err := newInterpreter(testCase.input).Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+
+    lex := lexer.NewLexer("0b1_01 + 0xff")
+    first, _ := lex.GetNextToken()
+    lex.GetNextToken()
+    last, _ := lex.GetNextToken()
+    if first.Literal != "0b1_01" || first.Value != 5 || last.Literal != "0xff" || last.Position != 9 {
+        t.Errorf("FAIL: tokens should keep their spelling and position: got %+v and %+v", first, last)
+    }
+
+    _, err := newInterpreter("1 + 0b102").Evaluate()
+    if err == nil || err.Error() != "lexer.Integer(): malformed binary literal 0b102: invalid digit '2' at column 9" {
+        t.Errorf("FAIL: malformed literal should be reported precisely: got %v", err)
+    }
+}
+
+func TestProgrammerMode(t *testing.T) {
+    testCases := []struct {
+        input          string
+        word           *interpreter.WordSize // nil for the default mode
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"6 & 3", nil, true, 2},
+        {"6 | 3", nil, true, 7},
+        {"1 << 4", nil, true, 16},
+        {"-16 >> 2", nil, true, -4},                       // arithmetic shift
+        {"~0", nil, true, -1},
+        {"1 | 2 & 3", nil, true, 3},                       // & binds tighter than |
+        {"1 + 1 << 2", nil, true, 8},                      // + binds tighter than <<
+        {"1 << 2 < 5", nil, true, true},                   // << binds tighter than <
+        {"6 & 3 == 2", nil, false, nil},                   // == binds tighter than &, like C
+        {"1 << 31", nil, false, nil},                      // overflow
+        {"1 << 40", nil, false, nil},
+        {"0 << 40", nil, true, 0},
+        {"(-2147483647 - 1) << 40", nil, false, nil},      // -2^31 * 2^33 would wrap to 0 in an int64
+        {"1 << -1", nil, false, nil},
+        {"3 ^ 5", nil, true, 243},                         // a power outside programmer mode
+        {"0xFFFF_FFFF", nil, false, nil},                  // out of range without a word size
+        {"3 ^ 5", interpreter.Int32, true, 6},
+        {"1 ^ 2 & 3", interpreter.Int32, true, 3},         // & binds tighter than ^
+        {"1 | 3 ^ 1", interpreter.Int32, true, 3},         // ^ binds tighter than |
+        {"0xFF + 1", interpreter.Uint8, true, 0},
+        {"~0", interpreter.Uint8, true, 255},
+        {"0 - 1", interpreter.Uint8, true, 255},
+        {"-1 >> 1", interpreter.Uint8, true, 127},         // logical shift
+        {"200 * 2", interpreter.Uint8, true, 144},
+        {"0 - 1 > 0", interpreter.Uint8, true, true},
+        {"1 << 8", interpreter.Uint8, true, 0},
+        {"1 / 0", interpreter.Uint8, false, nil},
+        {"127 + 1", interpreter.Int8, true, -128},
+        {"-128 / -1", interpreter.Int8, true, -128},
+        {"-1 >> 1", interpreter.Int8, true, -1},
+        {"0xFF", interpreter.Int8, true, -1},
+        {"300", interpreter.Int16, true, 300},
+        {"0x1_0000", interpreter.Int16, true, 0},
+        {"1 << -1", interpreter.Int16, false, nil},
+        {"0xFFFF_FFFF", interpreter.Uint32, true, 4294967295},
+        {"0xFFFF_FFFF + 1", interpreter.Uint32, true, 0},
+        {"0xFFFF_FFFF_FFFF_FFFF + 1", interpreter.Uint64, true, 0},
+        {"~0 > 1", interpreter.Uint64, true, true},
+        {"~0 / 2", interpreter.Uint64, true, 9223372036854775807},
+        {"~0 < 1", interpreter.Int64, true, true},
+        {"9223372036854775807 + 1 < 0", interpreter.Int64, true, true},
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Parser.Programmer = testCase.word != nil
+        interp.Word = testCase.word
+        result, err := interp.Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+
+    formatCases := []struct {
+        formatted string
+        expected  string
+    }{
+        {interpreter.Uint8.Format(255, 16), "0xff"},
+        {interpreter.Int8.Format(-1, 2), "0b11111111"},
+        {interpreter.Int8.Format(-1, 10), "-1"},
+        {interpreter.Uint64.Format(-1, 10), "18446744073709551615"},
+        {interpreter.Uint16.Format(8, 8), "0o10"},
+        {interpreter.FormatInteger(-31, 16), "-0x1f"},
+        {interpreter.FormatInteger(5, 2), "0b101"},
+    }
+    for _, formatCase := range formatCases {
+        if formatCase.formatted != formatCase.expected {
+            t.Errorf("FAIL: incorrect formatting: expected %s: got %s", formatCase.expected, formatCase.formatted)
+        }
+    }
+}
+
+func TestFormat(t *testing.T) {
+    testCases := []struct {
+        value    interface{}
+        settings [][2]string
+        expected string
+    }{
+        {1234567, nil, "1234567"},
+        {true, nil, "true"},
+        {2.5, nil, "2.5"},
+        {1234567, [][2]string{{"group", "on"}}, "1,234,567"},
+        {-12345.125, [][2]string{{"group", "on"}}, "-12,345.125"},
+        {6.62607015e-34, nil, "6.62607015e-34"},                        // the shortest form, as %v writes it
+        {3600000.0, nil, "3.6e+06"},
+        {3.6e+21, [][2]string{{"group", "on"}}, "3.6e+21"},
+        {123, [][2]string{{"group", "on"}}, "123"},
+        {255, [][2]string{{"base", "16"}}, "0xff"},
+        {-5, [][2]string{{"base", "2"}}, "-0b101"},
+        {2.5, [][2]string{{"base", "16"}}, "2.5"},                     // bases only apply to integers
+        {5, [][2]string{{"places", "2"}}, "5.00"},
+        {3.14159, [][2]string{{"places", "2"}}, "3.14"},
+        {3.14159, [][2]string{{"places", "0"}}, "3"},
+        {3.14159, [][2]string{{"sigfigs", "3"}}, "3.14"},
+        {1234567, [][2]string{{"sigfigs", "2"}}, "1200000"},
+        {0.000123456, [][2]string{{"sigfigs", "2"}}, "0.00012"},
+        {99.99, [][2]string{{"sigfigs", "2"}}, "100"},
+        {3.14159, [][2]string{{"places", "2"}, {"sigfigs", "4"}}, "3.142"}, // sigfigs win
+        {1234567, [][2]string{{"notation", "sci"}}, "1.234567e+06"},
+        {1234567, [][2]string{{"notation", "sci"}, {"sigfigs", "3"}}, "1.23e+06"},
+        {0.00124, [][2]string{{"notation", "sci"}, {"places", "1"}}, "1.2e-03"},
+        {12345, [][2]string{{"notation", "eng"}}, "12.345e+03"},
+        {-0.0012, [][2]string{{"notation", "eng"}}, "-1.2e-03"},
+        {1234567, [][2]string{{"notation", "eng"}, {"sigfigs", "2"}}, "1.2e+06"},
+        {123456, [][2]string{{"notation", "eng"}, {"sigfigs", "2"}}, "120e+03"},
+        {12345, [][2]string{{"notation", "eng"}, {"places", "1"}}, "12.3e+03"},
+        {0, [][2]string{{"notation", "eng"}}, "0e+00"},
+    }
+
+    for _, testCase := range testCases {
+        opts := format.Default()
+        for _, setting := range testCase.settings {
+            if err := opts.Set(setting[0], setting[1]); err != nil {
+                t.Fatalf("FAIL: could not set %s to %s: %v", setting[0], setting[1], err)
+            }
+        }
+        if formatted := format.Value(testCase.value, opts); formatted != testCase.expected {
+            t.Errorf("FAIL: formatting %v with %v: expected %s: got %s", testCase.value, testCase.settings,
+                testCase.expected, formatted)
+        }
+    }
+
+    opts := format.Default()
+    for _, setting := range [][2]string{{"base", "3"}, {"places", "-1"}, {"group", "yes"}, {"notation", "e"}, {"color", "on"}} {
+        if err := opts.Set(setting[0], setting[1]); err == nil {
+            t.Errorf("FAIL: no error setting %s to %s", setting[0], setting[1])
+        }
+    }
+}
+
+func TestImplicitMultiplication(t *testing.T) {
+    testCases := []struct {
+        input          string
+        implicit       bool
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"2(3 + 4)", false, false, nil},                   // only in implicit multiplication mode
+        {"(1 + 2)(3 + 4)", false, false, nil},
+        {"x = 3; 2x", false, false, nil},
+        {"2(3 + 4)", true, true, 14},
+        {"2 (3 + 4)", true, true, 14},
+        {"(1 + 2)(3 + 4)", true, true, 21},
+        {"(2)(3)(4)", true, true, 24},
+        {"x = 3; 2x", true, true, 6},
+        {"x = 3; 2x + 1", true, true, 7},                  // same precedence as *
+        {"x = 3; 1 + 2x * 2", true, true, 13},
+        {"6 / 2(1 + 2)", true, true, 9},                   // left to right, like 6 / 2 * (1 + 2)
+        {"-2(3)", true, true, -6},
+        {"1 1", true, false, nil},                         // two numbers are still an error
+        {"2 3(4)", true, false, nil},
+        {"x = 3; x 2", true, false, nil},                  // a name followed by a number is not implicit
+        {"x = 3; (1)x", true, false, nil},                 // nor is ')' followed by a name
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Parser.ImplicitMul = testCase.implicit
+        result, err := interp.Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+}
+
+func TestPostfix(t *testing.T) {
+    testCases := []struct {
+        input          string
+        overflow       interpreter.OverflowMode
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"5!", interpreter.OverflowCheck, true, 120},
+        {"0!", interpreter.OverflowCheck, true, 1},
+        {"3!!", interpreter.OverflowCheck, true, 720},
+        {"-3!", interpreter.OverflowCheck, true, -6},                 // -(3!)
+        {"2 * 3! + 1", interpreter.OverflowCheck, true, 13},
+        {"(1 + 2)!", interpreter.OverflowCheck, true, 6},
+        {"12!", interpreter.OverflowCheck, true, 479001600},
+        {"13!", interpreter.OverflowCheck, false, nil},               // beyond 32 bits
+        {"1000000!", interpreter.OverflowCheck, false, nil},
+        {"13!", interpreter.OverflowSaturate, true, interpreter.MaxInt},
+        {"1000000!", interpreter.OverflowWrap, true, 0},              // stops once the product has wrapped to 0
+        {"(-1)!", interpreter.OverflowCheck, false, nil},
+        {"2.5!", interpreter.OverflowCheck, false, nil},
+        {"3.0!", interpreter.OverflowCheck, true, 6},
+        {"true!", interpreter.OverflowCheck, false, nil},
+        {"5! == 120", interpreter.OverflowCheck, true, true},
+        {"3 != 4", interpreter.OverflowCheck, true, true},            // still not equal, not a factorial
+        {"200 + 10%", interpreter.OverflowCheck, true, float64(220)},
+        {"200 - 10%", interpreter.OverflowCheck, true, float64(180)},
+        {"50% * 80", interpreter.OverflowCheck, true, float64(40)},
+        {"80 * 50%", interpreter.OverflowCheck, true, float64(40)},
+        {"10%", interpreter.OverflowCheck, true, 0.1},
+        {"1.5 + 1", interpreter.OverflowCheck, true, 2.5},
+        {"-2.5 * 2", interpreter.OverflowCheck, true, float64(-5)},
+        {"1.5 < 2", interpreter.OverflowCheck, true, true},
+        {"1.5 / 0", interpreter.OverflowCheck, false, nil},
+        {"1.5 2", interpreter.OverflowCheck, false, nil},
+        {"1.", interpreter.OverflowCheck, false, nil},
+        {"%", interpreter.OverflowCheck, false, nil},
+        {"!", interpreter.OverflowCheck, false, nil},
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Overflow = testCase.overflow
+        result, err := interp.Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+}
+
+func TestHistory(t *testing.T) {
+    history := []interface{}{42, 2.5, true}
+    testCases := []struct {
+        input          string
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"ans", true, true},
+        {"_", true, true},
+        {"$1 + 1", true, 43},
+        {"$2 * 2", true, float64(5)},
+        {"$3 && false", true, false},
+        {"$1 * $1 - ans", false, nil},              // ans is a bool
+        {"$4", false, nil},                        // not yet a result
+        {"$0", false, nil},
+        {"$", false, nil},
+        {"$x", false, nil},
+        {"ans = 7; ans + $1", true, 49},           // an assigned variable hides the last result
+        {"answer", false, nil},
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.History = history
+        result, err := interp.Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+
+    interp := newInterpreter("ans + 1")
+    if _, err := interp.Evaluate(); err == nil {
+        t.Errorf("FAIL: ans with no previous result")
+    }
+}
+
+func TestConstants(t *testing.T) {
+    if err := interpreter.RegisterConstant("vat", 0.2, "", "value added tax rate"); err != nil {
+        t.Fatalf("FAIL: registering vat: %v", err)
+    }
+    for _, name := range []string{"", "2x", "if", "true", "a b", "x+1"} {
+        if err := interpreter.RegisterConstant(name, 1, "", ""); err == nil {
+            t.Errorf("FAIL: no error registering %q", name)
+        }
+    }
+
+    testCases := []struct {
+        input          string
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"pi", true, math.Pi},
+        {"2 * pi == tau", true, true},
+        {"e", true, math.E},
+        {"phi * phi - phi", true, math.Phi * math.Phi - math.Phi},
+        {"c", true, float64(299792458)},
+        {"100 * vat", true, float64(20)},                // registered from Go
+        {"pi = 3", false, nil},                          // read-only
+        {"vat = 0.25", false, nil},
+        {"for e in 1..3 { }", false, nil},
+        {"pie", false, nil},
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        result, err := interp.Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+
+    constant, ok := interpreter.LookupConstant("c")
+    if !ok || constant.Unit != "m/s" {
+        t.Errorf("FAIL: c should be documented in m/s, got %+v", constant)
+    }
+}
+
+func TestTrace(t *testing.T) {
+    testCases := []struct {
+        input    string
+        expected []string
+    }{
+        {"2 * (16 - 8) / 2 - 1", []string{"   2 * (16 - 8) / 2 - 1", "=  2 * 8 / 2 - 1", "=  16 / 2 - 1", "=  8 - 1", "=  7"}},
+        {"1 - (2 - 3)", []string{"   1 - (2 - 3)", "=  1 - -1", "=  2"}},
+        {"-(1 - 4) * 2", []string{"   -(1 - 4) * 2", "=  -(-3) * 2", "=  3 * 2", "=  6"}},
+        {"x = 2; x * 3! + 1", []string{"   x * 3! + 1", "=  2 * 3! + 1", "=  2 * 6 + 1", "=  12 + 1", "=  13"}},
+        {"1 < 2 && 3 > 4", []string{"   1 < 2 && 3 > 4", "=  true && 3 > 4", "=  true && false", "=  false"}},
+        {"true ? 1 + 1 : 2 + 2", []string{"   true ? 1 + 1 : 2 + 2", "=  true ? 2 : 2 + 2", "=  2"}},
+        {"for i in 1..2 { i }", []string{"   for i in 1..2 { i }", "=  for i in 1..2 { 1 }", "   for i in 1..2 { i }", "=  for i in 1..2 { 2 }"}},
+        {"7", nil},
+    }
+
+    for _, testCase := range testCases {
+        tracer := interpreter.NewTracer(newInterpreter(testCase.input))
+        _, err := tracer.Evaluate()
+        if testCase.expected == nil {
+            if len(tracer.Lines()) > 0 || err != nil {
+                t.Errorf("FAIL: %s: expected no steps, got %q", testCase.input, tracer.Lines())
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("FAIL: %s: %v", testCase.input, err)
+            continue
+        }
+        lines := tracer.Lines()
+        if len(lines) != len(testCase.expected) {
+            t.Errorf("FAIL: %s: expected %q, got %q", testCase.input, testCase.expected, lines)
+            continue
+        }
+        for i := range lines {
+            if lines[i] != testCase.expected[i] {
+                t.Errorf("FAIL: %s: expected %q, got %q", testCase.input, testCase.expected, lines)
+                break
+            }
+        }
+    }
+}
+
+func TestFunctions(t *testing.T) {
+    testCases := []struct {
+        input          string
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"f(x) = x * 2; f(21)", true, 42},
+        {"f(x) = x * 2", true, nil},                                       // a definition has no value
+        {"area(w, l) = w * l; area(3, 4) + area(1, 2)", true, 14},
+        {"fact(n) = n <= 1 ? 1 : n * fact(n - 1); fact(10)", true, 3628800},
+        {"x = 5; f(x) = x + 1; f(1) + x", true, 7},                         // the parameter hides x during the call
+        {"k = 3; f(x) = x * k; k = 4; f(2)", true, 8},                     // other variables are looked up when called
+        {"f() = 7; f() * 2", true, 14},
+        {"f(x) = x; f(x) = x * 3; f(2)", true, 6},                          // redefined
+        {"f(x) = { y = x * 2; y + 1 }; f(4)", true, 9},
+        {"g(1)", false, nil},                                              // undefined
+        {"f(x) = x; f(1, 2)", false, nil},                                 // wrong number of arguments
+        {"f(x, x) = x", false, nil},
+        {"f(1) = 1", false, nil},
+        {"pi(x) = x", false, nil},                                         // a constant
+        {"diff(a, b) = a - b", false, nil},                                // a REPL command
+        {"f(e) = e", false, nil},
+        {"f(x) = x; f(1", false, nil},
+        {"f(x) = x; f(1,)", false, nil},
+        {"while true { f(x) = { break } }", false, nil},                   // break does not leave the function
+        {"f(n) = f(n + 1); f(1)", false, nil},                             // stopped by the depth limit
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Limits.MaxDepth = 1000
+        result, err := interp.Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+}
+
+func TestSession(t *testing.T) {
+    dir := t.TempDir()
+    saved, err := newSession()
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved.set("base", "16")
+    saved.set("implicit", "on")
+    for _, input := range []string{"x = 2.5", "n = 7", "b = 1 < 2", "f(a) = a * 2x", "10 * 22%", "f(1)", "n * 3"} {
+        result, _, err := saved.evaluate(input)
+        if err != nil {
+            t.Fatalf("FAIL: %s: %v", input, err)
+        }
+        if result != nil {
+            saved.history = append(saved.history, result)
+        }
+    }
+    path := dir + "/session.txt"
+    if err := saved.save(path); err != nil {
+        t.Fatal(err)
+    }
+
+    loaded, _ := newSession()
+    if err := loaded.load(path); err != nil {
+        t.Fatalf("FAIL: loading: %v", err)
+    }
+    if strings.Join(loaded.settings(), " ") != strings.Join(saved.settings(), " ") {
+        t.Errorf("FAIL: settings %v, expected %v", loaded.settings(), saved.settings())
+    }
+    expectedHistory := []interface{}{2.5, 7, true, 2.2, float64(5), 21}
+    if len(loaded.history) != len(expectedHistory) {
+        t.Fatalf("FAIL: history %v, expected %v", loaded.history, expectedHistory)
+    }
+    for i := range expectedHistory {
+        if loaded.history[i] != expectedHistory[i] {
+            t.Errorf("FAIL: $%d is %#v, expected %#v", i + 1, loaded.history[i], expectedHistory[i])
+        }
+    }
+    for _, input := range []string{"f(3) == 15.0", "x == 2.5 && n == 7 && b", "$5 == 5.0"} {
+        result, _, err := loaded.evaluate(input)
+        if err != nil || result != true {
+            t.Errorf("FAIL: %s after loading: %v %v", input, result, err)
+        }
+    }
+
+    // errors name the line, and leave the session as it was 
+    badFiles := map[string]string{
+        "[settings]\nbase = 3\n":                        ":2:",
+        "[variables]\nx = 1\ny = 2 +\n":                  ":3:",
+        "\n[functions]\nf(x) = x\ng(x) = \n":             ":4:",
+        "[functions]\nx = 1\n":                          ":2:",
+        "[history]\n$2 = 1\n":                           ":2:",
+        "[variables]\npi = 3\n":                         ":2:",
+        "x = 1\n":                                       ":1:",
+        "[values]\n":                                    ":1:",
+    }
+    for contents, line := range badFiles {
+        bad := dir + "/bad.txt"
+        os.WriteFile(bad, []byte(contents), 0644)
+        err := loaded.load(bad)
+        if err == nil || !strings.Contains(err.Error(), line) {
+            t.Errorf("FAIL: loading %q: expected an error on line %s, got %v", contents, line, err)
+        }
+    }
+    if len(loaded.history) != len(expectedHistory) || loaded.functions["f"] == nil {
+        t.Errorf("FAIL: failed load changed the session")
+    }
+}
+
+func TestConfig(t *testing.T) {
+    dir := t.TempDir()
+    path := dir + "/config"
+    config := "# defaults\n[settings]\nplaces = 2\nimplicit = on\n\n[definitions]\nrate = 0.07  # sales tax\n" +
+        "tax(x) = x * rate\ndouble(x) = 2x; half(x) = x / 2\n"
+    os.WriteFile(path, []byte(config), 0644)
+    s := &session{format: format.Default()}
+    s.clear()
+    if err := s.configure(path); err != nil {
+        t.Fatalf("FAIL: %v", err)
+    }
+    if s.format.Places != 2 || !s.implicit {
+        t.Errorf("FAIL: settings not applied: %v", s.settings())
+    }
+    result, _, err := s.evaluate("tax(100) + double(half(3))")
+    if err != nil || result != 9.0 { // 7.0 + 2 * (3 / 2)
+        t.Errorf("FAIL: definitions not applied: %v %v", result, err)
+    }
+    if err := s.configure("none"); err != nil {
+        t.Errorf("FAIL: none: %v", err)
+    }
+    if err := s.configure(dir + "/missing"); err == nil {
+        t.Errorf("FAIL: no error for a missing file")
+    }
+
+    badFiles := map[string]string{
+        "[settings]\nplaces = two\n":                     ":2:",
+        "[settings]\nimplicit\n":                         ":2:",
+        "[definitions]\nx = 1\ny = (2\n":                 ":3:",
+        "[definitions]\nx = 1\n\n3 + 4\n":                ":4:",
+        "[definitions]\nf(x) = x $ 2\n":                  ":2:",
+        "[definitions]\npi = 3\n":                        ":2:",
+        "[settings]\n[session]\n":                        ":2:",
+    }
+    for contents, line := range badFiles {
+        os.WriteFile(path, []byte(contents), 0644)
+        err := s.configure(path)
+        if err == nil || !strings.Contains(err.Error(), path + line) {
+            t.Errorf("FAIL: %q: expected an error on line %s, got %v", contents, line, err)
+        }
+    }
+}
+
+// parse parses an input for the tests that work on trees
+func parse(t *testing.T, input string) ast.ASTNode {
+    p, err := parser.NewParser(lexer.NewLexer(input))
+    if err != nil {
+        t.Fatalf("FAIL: %s: %v", input, err)
+    }
+    p.ImplicitMul = true
+    root, err := p.Parse()
+    if err != nil {
+        t.Fatalf("FAIL: %s: %v", input, err)
+    }
+    return root.(*ast.Block).Statements[0]
+}
+
+func TestPower(t *testing.T) {
+    testCases := []struct {
+        input          string
+        overflow       interpreter.OverflowMode
+        shouldPass     bool
+        expectedResult interface{}
+    }{
+        {"2 ^ 10", interpreter.OverflowCheck, true, 1024},
+        {"2 ^ 3 ^ 2", interpreter.OverflowCheck, true, 512},         // right associative
+        {"-2 ^ 2", interpreter.OverflowCheck, true, -4},             // -(2^2)
+        {"(-2) ^ 3", interpreter.OverflowCheck, true, -8},
+        {"2 * 3 ^ 2", interpreter.OverflowCheck, true, 18},
+        {"3! ^ 2", interpreter.OverflowCheck, true, 36},
+        {"2 ^ -1", interpreter.OverflowCheck, true, 0.5},
+        {"4 ^ 0.5", interpreter.OverflowCheck, true, float64(2)},
+        {"0 ^ 0", interpreter.OverflowCheck, true, 1},
+        {"(-1) ^ 2147483647", interpreter.OverflowCheck, true, -1},
+        {"(-2) ^ 31", interpreter.OverflowCheck, true, interpreter.MinInt},
+        {"2 ^ 31", interpreter.OverflowCheck, false, nil},
+        {"3 ^ 1000000", interpreter.OverflowCheck, false, nil},
+        {"2 ^ 31", interpreter.OverflowSaturate, true, interpreter.MaxInt},
+        {"(-3) ^ 99", interpreter.OverflowSaturate, true, interpreter.MinInt},
+        {"2 ^ 32", interpreter.OverflowWrap, true, 0},
+        {"3 ^ 21", interpreter.OverflowWrap, true, 1870418611},      // 3^21 mod 2^32
+        {"0 ^ -1", interpreter.OverflowCheck, false, nil},
+        {"(-8) ^ 0.5", interpreter.OverflowCheck, false, nil},
+        {"sqrt(16) + abs(-3)", interpreter.OverflowCheck, true, float64(7)},
+        {"abs(-3)", interpreter.OverflowCheck, true, 3},
+        {"ln(0)", interpreter.OverflowCheck, false, nil},
+        {"sqrt(-1)", interpreter.OverflowCheck, false, nil},
+        {"sin(1, 2)", interpreter.OverflowCheck, false, nil},
+        {"sin(x) = x", interpreter.OverflowCheck, false, nil},       // built-in functions cannot be redefined
+    }
+
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Overflow = testCase.overflow
+        result, err := interp.Evaluate()
+        checkResult(t, testCase.input, testCase.shouldPass, testCase.expectedResult, result, err)
+    }
+}
+
+func TestDerivative(t *testing.T) {
+    testCases := []struct {
+        input    string
+        expected string
+    }{
+        {"x^2 * 3 + x", "6 * x + 1"},
+        {"x^3 - 4x^2 + 7", "3 * x ^ 2 - 8 * x"},
+        {"5", "0"},
+        {"y^2", "0"},
+        {"x", "1"},
+        {"-x", "-1"},
+        {"3 * (x * 4)", "12"},
+        {"sin(x)", "cos(x)"},
+        {"cos(3x)", "-(3 * sin(3 * x))"},
+        {"exp(2x)", "2 * exp(2 * x)"},
+        {"1 / x", "-1 / x ^ 2"},
+        {"x^x", "x ^ x * (ln(x) + 1)"},
+        {"2^x", "2 ^ x * ln(2)"},
+        {"e^x", "e ^ x"},
+        {"e^(2x)", "2 * e ^ (2 * x)"},
+        {"x / (1 + x)", "1 / (1 + x) ^ 2"},
+        {"x^3 + 2 * x^3 - x", "9 * x ^ 2 - 1"},
+    }
+    for _, testCase := range testCases {
+        derivative, err := calculus.Derivative(parse(t, testCase.input), "x")
+        if err != nil {
+            t.Errorf("FAIL: d/dx %s: %v", testCase.input, err)
+            continue
+        }
+        if text := interpreter.Source(derivative); text != testCase.expected {
+            t.Errorf("FAIL: d/dx %s: expected %s, got %s", testCase.input, testCase.expected, text)
+        }
+    }
+
+    for input, expected := range map[string]string{"2 * x ^ 2 + x ^ 2": "3 * x ^ 2", "1 + x - x": "1", "x - x": "0",
+        "x - 2 * y + 3 * x + y": "4 * x - y", "-x + 1 - (2 - x)": "-1", "x + y": "x + y"} {
+        if text := interpreter.Source(calculus.Simplify(parse(t, input))); text != expected {
+            t.Errorf("FAIL: simplify %s: expected %s, got %s", input, expected, text)
+        }
+    }
+    if err := interpreter.RegisterConstant("e", 3, "", "not Euler's number"); err != nil {
+        t.Fatal(err)
+    }
+    text := interpreter.Source(calculus.Simplify(parse(t, "ln(e)")))
+    interpreter.RegisterConstant("e", math.E, "", "base of the natural logarithm")
+    if text != "ln(e)" {
+        t.Errorf("FAIL: simplify ln(e) with e = 3: expected ln(e), got %s", text)
+    }
+
+    // the derivative of anything else agrees with a difference quotient 
+    for _, input := range []string{"x / (1 + x)", "sqrt(1 - x^2)", "tan(x) * x", "ln(x^2 + 1) / x", "atan(2x) - acos(x / 2)",
+        "asin(x) + log(x)", "abs(x - 1) ^ 3", "x * x * x", "(x + 1) ^ (x - 1)", "-x^2 / e^x"} {
+        derivative, err := calculus.Derivative(parse(t, input), "x")
+        if err != nil {
+            t.Errorf("FAIL: d/dx %s: %v", input, err)
+            continue
+        }
+        at := func(input string, x float64) float64 {
+            interp := newInterpreter(input)
+            interp.Parser.ImplicitMul = true
+            interp.Variables["x"] = x
+            result, err := interp.Evaluate()
+            if err != nil {
+                t.Fatalf("FAIL: %s at %v: %v", input, x, err)
+            }
+            return result.(float64)
+        }
+        const x, h = 0.3, 1e-6
+        expected := (at(input, x + h) - at(input, x - h)) / (2 * h)
+        if got := at(interpreter.Source(derivative), x); math.Abs(got - expected) > 1e-5 {
+            t.Errorf("FAIL: d/dx %s = %s is %v at %v, expected %v", input, interpreter.Source(derivative), got, x, expected)
+        }
+    }
+
+    for _, input := range []string{"x < 1", "x!", "f(x)", "x > 0 ? x : -x", "{ x }", "transpose(x)", "sum(x)", "mean(x)",
+        "re(x)", "2 * sort(x + 1)"} {
+        if _, err := calculus.Derivative(parse(t, input), "x"); err == nil {
+            t.Errorf("FAIL: no error differentiating %s", input)
+        }
+    }
+
+    // diff and solve are commands of the REPL, on a line of their own, and not values in an expression
+    s, err := newSession()
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, input := range []string{"1 + diff(x^2, x)", "y = 1; solve(x^2 = 4, x)", "f(x) = diff(x, x); f(1)"} {
+        if text, ok, _ := s.calculus(input); ok {
+            t.Errorf("FAIL: %s is handled as a command, giving %s", input, text)
+        }
+        if _, _, err := s.evaluate(input); err == nil || !strings.Contains(err.Error(), "only available as a top-level REPL command") {
+            t.Errorf("FAIL: %s: expected an error that it is a REPL command, got %v", input, err)
+        }
+    }
+}
+
+func TestSolve(t *testing.T) {
+    testCases := []struct {
+        input     string
+        low, high float64
+        expected  []float64
+    }{
+        {"x^2 = 4", calculus.DefaultLow, calculus.DefaultHigh, []float64{-2, 2}},
+        {"x^3 - 6x^2 + 11x = 6", calculus.DefaultLow, calculus.DefaultHigh, []float64{1, 2, 3}},
+        {"x^2 = 0", calculus.DefaultLow, calculus.DefaultHigh, []float64{0}},
+        {"sin(x) = 0", -7, 7, []float64{-2 * math.Pi, -math.Pi, 0, math.Pi, 2 * math.Pi}},
+        {"exp(x) = 10", -5, 5, []float64{math.Log(10)}},
+        {"1 / x = 2", 0.1, 10, []float64{0.5}},
+        {"x^2 = 4", 0, 10, []float64{2}},
+        {"x = x + 1", calculus.DefaultLow, calculus.DefaultHigh, nil},
+    }
+    for _, testCase := range testCases {
+        interp := newInterpreter("")
+        equation := parse(t, "solve(" + testCase.input + ", x)").(*ast.Call).Arguments[0].(*ast.Equation)
+        roots, err := calculus.Solve(interp, equation, "x", testCase.low, testCase.high)
+        if err != nil {
+            t.Errorf("FAIL: solve %s: %v", testCase.input, err)
+            continue
+        }
+        if len(roots) != len(testCase.expected) {
+            t.Errorf("FAIL: solve %s: expected %v, got %v", testCase.input, testCase.expected, roots)
+            continue
+        }
+        for i, root := range roots {
+            if math.Abs(root - testCase.expected[i]) > 1e-9 {
+                t.Errorf("FAIL: solve %s: expected %v, got %v", testCase.input, testCase.expected, roots)
+                break
+            }
+        }
+        if _, ok := interp.Variables["x"]; ok {
+            t.Errorf("FAIL: solve %s: x is left defined", testCase.input)
+        }
+    }
+
+    solve := func(input string) error {
+        equation := parse(t, "solve(" + input + ", x)").(*ast.Call).Arguments[0].(*ast.Equation)
+        _, err := calculus.Solve(newInterpreter(""), equation, "x", calculus.DefaultLow, calculus.DefaultHigh)
+        return err
+    }
+    for _, input := range []string{"x^2 + 1 = 0", "x^2 = -1"} {
+        err := solve(input)
+        if !errors.Is(err, calculus.ErrNoSolution) || !errors.Is(err, calculus.ErrNoConvergence) ||
+            !strings.Contains(err.Error(), "in [-100, 100]") || !strings.Contains(err.Error(), "near x = 0") {
+            t.Errorf("FAIL: solve %s: expected no solution in [-100, 100] and no convergence near x = 0, got %v", input, err)
+        }
+    }
+
+    // a failure of Newton's method is reported with the roots found elsewhere
+    equation := parse(t, "solve((x^2 + 0.01) * (x - 50) = 0, x)").(*ast.Call).Arguments[0].(*ast.Equation)
+    roots, err := calculus.Solve(newInterpreter(""), equation, "x", calculus.DefaultLow, calculus.DefaultHigh)
+    if len(roots) != 1 || roots[0] != 50 || !errors.Is(err, calculus.ErrNoConvergence) || errors.Is(err, calculus.ErrNoSolution) {
+        t.Errorf("FAIL: solve (x^2 + 0.01) * (x - 50) = 0: expected 50 and no convergence, got %v, %v", roots, err)
+    }
+    if err := solve("1 / x = 2"); !errors.Is(err, interpreter.ErrDivisionByZero) {
+        t.Errorf("FAIL: solve 1 / x = 2: expected division by zero, got %v", err)
+    }
+    if err := solve("x < 2 = 1"); err == nil {
+        t.Errorf("FAIL: no error solving x < 2 = 1")
+    }
+
+    // the step limit bounds the whole search, each of the 2001 probes takes 2 steps
+    interp := newInterpreter("")
+    interp.Limits.MaxSteps = 1000
+    equation = parse(t, "solve(x = 1, x)").(*ast.Call).Arguments[0].(*ast.Equation)
+    if _, err := calculus.Solve(interp, equation, "x", calculus.DefaultLow, calculus.DefaultHigh); !errors.Is(err, interpreter.ErrLimitExceeded) {
+        t.Errorf("FAIL: solve x = 1: expected the step limit, got %v", err)
+    }
+}
+
+func TestSolveLinear(t *testing.T) {
+    testCases := []struct {
+        input    string
+        expected string
+    }{
+        {"solve {2x + y = 5; x - y = 1}", "x = 2, y = 1"},
+        {"solve {x + y = 1; 3x - y = 0}", "x = 1/4, y = 3/4"},
+        {"solve {\n x + y + z = 6\n 2x - y = 0\n z = 3 }", "x = 1, y = 2, z = 3"},
+        {"solve {x / 2 + -y = 0; 2 * (x - 1) = y + 1}", "x = 2, y = 1"},
+        {"solve {x^1 = 0.3}", "x = 3/10"},
+        {"solve {0.1x = 1}", "x = 10"},
+        {"solve {a + b = budget; a = 3b}", "a = 75, b = 25"},
+        {"solve {pi * x = 2pi}", "x = 2"},
+        {"solve {a + b + c = 6; a - b = 0; c = 2}", "a = 2, b = 2, c = 2"}, // c and i are unknowns
+        {"solve {i + g = 3; i - g = 1}", "i = 2, g = 1"},
+    }
+    for _, testCase := range testCases {
+        call := parse(t, testCase.input).(*ast.Call)
+        equations := make([]*ast.Equation, 0)
+        for _, statement := range call.Arguments[0].(*ast.Block).Statements {
+            equations = append(equations, statement.(*ast.Equation))
+        }
+        interp := newInterpreter("")
+        interp.Variables["budget"] = 100
+        solution, err := calculus.SolveLinear(interp, equations)
+        if err != nil {
+            t.Errorf("FAIL: %s: %v", testCase.input, err)
+            continue
+        }
+        values := make([]string, len(solution.Names))
+        for i, name := range solution.Names {
+            values[i] = name + " = " + solution.Values[i].RatString()
+        }
+        if got := strings.Join(values, ", "); got != testCase.expected {
+            t.Errorf("FAIL: %s: expected %s, got %s", testCase.input, testCase.expected, got)
+        }
+    }
+
+    errorCases := []struct {
+        input    string
+        expected error // nil for any error
+    }{
+        {"solve {x + y = 1; 2x + 2y = 2}", calculus.ErrSingular},
+        {"solve {x + y = 1; x + y = 2}", calculus.ErrInconsistent},
+        {"solve {x = 1; x = 2}", calculus.ErrInconsistent},
+        {"solve {x / 0 = 1}", interpreter.ErrDivisionByZero},
+        {"solve {x * y = 1; x = 2}", nil},
+        {"solve {x^2 = 1}", nil},
+        {"solve {sin(x) = 0}", nil},
+        {"solve {1 = 1}", nil},
+    }
+    for _, testCase := range errorCases {
+        call := parse(t, testCase.input).(*ast.Call)
+        equations := make([]*ast.Equation, 0)
+        for _, statement := range call.Arguments[0].(*ast.Block).Statements {
+            equations = append(equations, statement.(*ast.Equation))
+        }
+        _, err := calculus.SolveLinear(newInterpreter(""), equations)
+        if err == nil || testCase.expected != nil && !errors.Is(err, testCase.expected) {
+            t.Errorf("FAIL: %s: expected %v, got %v", testCase.input, testCase.expected, err)
+        }
+    }
+
+    if text := interpreter.Source(parse(t, "solve {2x + y = 5; x - y = 1}")); text != "solve { 2 * x + y = 5; x - y = 1 }" {
+        t.Errorf("FAIL: source of a system: got %s", text)
+    }
+
+    // a system multiplies implicitly whatever the setting, and a name after a number in it is not a unit
+    p, _ := parser.NewParser(lexer.NewLexer("solve {2m + n = 5; m - n = 1}"))
+    if root, err := p.Parse(); err != nil || interpreter.Source(root.(*ast.Block).Statements[0]) != "solve { 2 * m + n = 5; m - n = 1 }" {
+        t.Errorf("FAIL: system without implicit multiplication: got %v, %v", root, err)
+    }
+    p, _ = parser.NewParser(lexer.NewLexer("solve {2x = 1}; 2x"))
+    if _, err := p.Parse(); err == nil {
+        t.Errorf("FAIL: implicit multiplication is left on after a system")
+    }
+}
+
+func TestMatrices(t *testing.T) {
+    testCases := []struct {
+        input      string
+        shouldPass bool
+        expected   string // the result as format.Value writes it, or part of the error message
+    }{
+        {"[1, 2; 3, 4]", true, "[1, 2; 3, 4]"},
+        {"[1, 2\n 3, 4\n]", true, "[1, 2; 3, 4]"},
+        {"[1 + 1, 2 * 3]", true, "[2, 6]"},
+        {"A = [1, 2; 3, 4]; A * A", true, "[7, 10; 15, 22]"},
+        {"A = [1, 2; 3, 4]; A .* A", true, "[1, 4; 9, 16]"},
+        {"[1, 2; 3, 4] - [1, 1; 1, 1]", true, "[0, 1; 2, 3]"},
+        {"[1, 2, 3] * [1; 2; 3]", true, "[14]"},
+        {"[1; 2] * [3, 4]", true, "[3, 4; 6, 8]"},
+        {"2 * [1, 2] / 4.0", true, "[0.5, 1]"},
+        {"-[1, -2]", true, "[-1, 2]"},
+        {"[1, 1; 1, 0] ^ 10", true, "[89, 55; 55, 34]"},
+        {"[1, 2; 3, 4] ^ 0", true, "[1, 0; 0, 1]"},
+        {"transpose([1, 2, 3; 4, 5, 6])", true, "[1, 4; 2, 5; 3, 6]"},
+        {"det([1, 2; 3, 4])", true, "-2"},
+        {"det([2, 0, 1; 1, 3, 2; 1, 1, 2])", true, "6"},
+        {"det([0.5, 1; 1, 4])", true, "1"},
+        {"inv([1, 2; 3, 4])", true, "[-2, 1; 1.5, -0.5]"},
+        {"inv([2, 1; 1, 1])", true, "[1, -1; -1, 2]"},
+        {"[1, 2; 3, 4] ^ -1 * [1, 2; 3, 4]", true, "[1, 0; 0, 1]"},
+        {"[1, 2] == [1, 2]", true, "true"},
+        {"[1, 2] != [1; 2]", true, "true"},
+        {"[1, 2] + [1, 2, 3]", false, "dimension mismatch: cannot apply + to a 1x2 and a 1x3 matrix at column 8"},
+        {"[1, 2, 3] * [1, 2]", false, "dimension mismatch: cannot multiply a 1x3 by a 1x2 matrix, 3 columns but 1 rows at column 11"},
+        {"[1, 2] .* [1; 2]", false, "dimension mismatch"},
+        {"det([1, 2, 3])", false, "dimension mismatch: det needs a square matrix, not a 1x3 one at column 1"},
+        {"[1, 2] ^ 2", false, "dimension mismatch"},
+        {"inv([1, 2; 2, 4])", false, "singular"},
+        {"[1, 2; 3]", false, "row 2 has 1 elements, row 1 has 2 at column 8"},
+        {"[;]", false, "empty matrix"},
+        {"[1, true;]", false, "matrix element 1,2 is bool"},
+        {"[[1; 2];]", false, "matrix element 1,1 is matrix"},
+        {"[1, 2;] + 1", false, "cannot apply + to matrix and int"},
+        {"sin([1;])", false, "cannot apply sin to matrix"},
+        {"det(2)", false, "cannot apply det to int"},
+        {"[65536, 0; 0, 65536] * [65536, 0; 0, 1]", false, "overflow"},
+        {"[1, 2", false, ""},
+        {"1, 2]", false, ""},
+    }
+    for _, testCase := range testCases {
+        result, err := newInterpreter(testCase.input).Evaluate()
+        if err != nil {
+            if testCase.shouldPass || !strings.Contains(err.Error(), testCase.expected) {
+                t.Errorf("FAIL: %q: expected %s, got error %v", testCase.input, testCase.expected, err)
+            }
+            continue
+        }
+        if got := format.Value(result, format.Default()); !testCase.shouldPass || got != testCase.expected {
+            t.Errorf("FAIL: %q: expected %s, got %s", testCase.input, testCase.expected, got)
+        }
+    }
+
+    // matrices are saved and loaded with a session
+    s, err := newSession()
+    if err != nil {
+        t.Fatal(err)
+    }
+    s.variables["m"] = &interpreter.Matrix{Rows: 1, Columns: 2, Elements: []interface{}{1, 2.0}}
+    path := t.TempDir() + "/session"
+    if err := s.save(path); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.load(path); err != nil {
+        t.Fatal(err)
+    }
+    if m, ok := s.variables["m"].(*interpreter.Matrix); !ok || m.Elements[0] != 1 || m.Elements[1] != 2.0 {
+        t.Errorf("FAIL: matrix after save and load: %v", s.variables["m"])
+    }
+}
+
+func TestBrackets(t *testing.T) {
+    testCases := []struct {
+        input       string
+        open, close int // columns of the brackets in the error, 0 for none
+    }{
+        {"(1 + 2]", 1, 7},
+        {"[1, 2)", 1, 6},
+        {"{1 + 2)", 1, 7},
+        {"(1 + [2, 3)]", 6, 11},
+        {"((1 + 2) * 3]", 1, 13},
+        {"{ (1 }", 3, 6},
+        {"(1 + 2", 1, 0},
+        {"[1, (2", 5, 0},
+        {"1 + 2)", 0, 6},
+        {")", 0, 1},
+        {"]", 0, 1},
+    }
+    for _, testCase := range testCases {
+        p, err := parser.NewParser(lexer.NewLexer(testCase.input))
+        if err == nil {
+            _, err = p.Parse()
+        }
+        var mismatch *nestingstack.MismatchError
+        if !errors.As(err, &mismatch) {
+            t.Errorf("FAIL: %s: expected a bracket mismatch, got %v", testCase.input, err)
+            continue
+        }
+        open, close := 0, 0
+        if mismatch.Open.TokenType != "" {
+            open = mismatch.Open.Position + 1
+        }
+        if mismatch.Close.TokenType != "EOF" {
+            close = mismatch.Close.Position + 1
+        }
+        if open != testCase.open || close != testCase.close {
+            t.Errorf("FAIL: %s: expected brackets at columns %d and %d, got %d and %d (%v)", testCase.input,
+                testCase.open, testCase.close, open, close, err)
+        }
+    }
+
+    for _, input := range []string{"(1 + 2) * {3}", "[(1 + 2), {3}]", "{ x = ([2] * [1, 1] + [3, 3]); x }"} {
+        if _, err := newInterpreter(input).Evaluate(); err != nil {
+            t.Errorf("FAIL: %s: %v", input, err)
+        }
+    }
+}
+
+func TestLists(t *testing.T) {
+    testCases := []struct {
+        input      string
+        shouldPass bool
+        expected   string // the result as format.Value writes it, or part of the error message
+    }{
+        {"[3, 1, 2]", true, "[3, 1, 2]"},
+        {"[]", true, "[]"},
+        {"[3,]", true, "[3,]"},
+        {"[1 + 2] * 3", true, "9"}, // a single expression in brackets is grouped
+        {"[[1, 2]] * 2", true, "[2, 4]"},
+        {"1..1", true, "[1,]"},
+        {"1..5", true, "[1, 2, 3, 4, 5]"},
+        {"n = 3; 1..n + 1", true, "[1, 2, 3, 4]"},
+        {"5..1", true, "[]"},
+        {"-2..0", true, "[-2, -1, 0]"},
+        {"xs = [3, 1, 2]; xs[1] + xs[-1]", true, "5"},
+        {"(1..10)[3]", true, "3"},
+        {"[1, 2; 3, 4][2]", true, "[3, 4]"},
+        {"[1, 2; 3, 4][2, 1]", true, "3"},
+        {"[1, 2; 3, 4][-1, -1]", true, "4"},
+        {"sum([1, 2, 3])", true, "6"},
+        {"sum(1..100)", true, "5050"},
+        {"sum([1.5, 2])", true, "3.5"},
+        {"sum([])", true, "0"},
+        {"avg([1, 2, 3, 4])", true, "2.5"},
+        {"avg(1, 2)", true, "1.5"},
+        {"min([3, 1.5, 2])", true, "1.5"},
+        {"max(3, 7, 2)", true, "7"},
+        {"count(1..10)", true, "10"},
+        {"count([])", true, "0"},
+        {"sort([3, -1, 2.5, 0])", true, "[-1, 0, 2.5, 3]"},
+        {"sum([1, 2; 3, 4])", true, "10"},
+        {"[1, 2] * 3", true, "[3, 6]"},
+        {"[2, 4] / 2", true, "[1, 2]"},
+        {"[1, 2] + [3, 4]", true, "[4, 6]"},
+        {"[1, 2] .* [3, 4]", true, "[3, 8]"},
+        {"-[1, 2]", true, "[-1, -2]"},
+        {"[1, 2] == [1, 2]", true, "true"},
+        {"[1, 2] * [1; 1]", true, "[3]"},
+        {"x = 0; for i in 1..4 { x = x + i }; x", true, "10"},
+        {"[1, 2] + 1", false, "cannot apply + to list and int"},
+        {"[1, 2] < [3, 4]", false, "cannot apply < to list and list"},
+        {"[1, 2] + [1, 2, 3]", false, "dimension mismatch"},
+        {"[1, 2] && true", false, "type error"},
+        {"[1, true]", false, "list element 2 is bool, not a number"},
+        {"[[1,],]", false, "list element 1 is list"},
+        {"[1, 2][3]", false, "index 3 is out of range for a list of 2 elements at column 7"},
+        {"[1, 2][0]", false, "out of range"},
+        {"[1, 2][1, 1]", false, "too many indices"},
+        {"[1, 2][1.0]", false, "index must be int"},
+        {"5[1]", false, "cannot index int"},
+        {"1..2.5", false, "range bounds must be int"},
+        {"avg([])", false, "avg of no numbers"},
+        {"max([])", false, "max of no numbers"},
+        {"sum([1, 2], 3)", false, "cannot apply sum to list"},
+        {"sum(true)", false, "cannot apply sum to bool"},
+        {"sum([2147483647, 1])", false, "overflow"},
+        {"sin([1, 2])", false, "cannot apply sin to list"},
+        {"sum(x) = x", false, "cannot redefine built-in function sum"},
+    }
+    for _, testCase := range testCases {
+        result, err := newInterpreter(testCase.input).Evaluate()
+        if err != nil {
+            if testCase.shouldPass || !strings.Contains(err.Error(), testCase.expected) {
+                t.Errorf("FAIL: %q: expected %s, got error %v", testCase.input, testCase.expected, err)
+            }
+            continue
+        }
+        if got := format.Value(result, format.Default()); !testCase.shouldPass || got != testCase.expected {
+            t.Errorf("FAIL: %q: expected %s, got %s", testCase.input, testCase.expected, got)
+        }
+    }
+
+    // a range is bounded by the iteration limit
+    interp := newInterpreter("1..100000")
+    interp.Limits.MaxIterations = 1000
+    if _, err := interp.Evaluate(); !errors.Is(err, interpreter.ErrLimitExceeded) {
+        t.Errorf("FAIL: 1..100000 with an iteration limit of 1000: expected a limit error, got %v", err)
+    }
+
+    // written out and parsed again, lists, ranges and indices give the same tree
+    for _, input := range []string{"[1, 2 + 3][1]", "(1..3)[2]", "1..n + 1", "c ? 1..2 : 3..4", "(c ? 1 : 2)..3",
+        "for i in (c ? 1 : 2)..3 { i }", "[1, 2;] * [3; 4]", "-xs[1]^2", "[n,] * [n + 1] * 2"} {
+        first := interpreter.Source(parse(t, input))
+        if second := interpreter.Source(parse(t, first)); first != second {
+            t.Errorf("FAIL: %s is written as %s, which is written as %s", input, first, second)
+        }
+    }
+}
+
+func TestStatistics(t *testing.T) {
+    testCases := []struct {
+        input      string
+        rational   bool
+        shouldPass bool
+        expected   string // the result as format.Value writes it, or part of the error message
+    }{
+        {"mean([1, 2, 3, 4])", false, true, "2.5"},
+        {"mean([1, 2, 3])", false, true, "2"},
+        {"mean([1, 2; 3, 4])", false, true, "2.5"},
+        {"median([3, 1, 2])", false, true, "2"},
+        {"median([4, 1, 3, 2])", false, true, "2.5"},
+        {"mode([1, 2, 2, 3, 3])", false, true, "2"},
+        {"mode([5,])", false, true, "5"},
+        {"variance([2, 4, 4, 4, 5, 5, 7, 9])", false, true, "4.571428571428571"},
+        {"pvariance([2, 4, 4, 4, 5, 5, 7, 9])", false, true, "4"},
+        {"pstddev([2, 4, 4, 4, 5, 5, 7, 9])", false, true, "2"},
+        {"stddev([1, 3])", false, true, "1.4142135623730951"},
+        {"variance([1000000004.0, 1000000007.0, 1000000013.0, 1000000016.0])", false, true, "30"},
+        {"percentile([1, 2, 3, 4, 5], 25)", false, true, "2"},
+        {"percentile([1, 2, 3, 4], 50)", false, true, "2.5"},
+        {"percentile([1, 2, 3, 4], 100)", false, true, "4"},
+        {"correlation([1, 2, 3], [2, 4, 6])", false, true, "1"},
+        {"correlation([1, 2, 3], [3, 2, 1])", false, true, "-1"},
+        {"regression([1, 2, 3], [3, 5, 7])", false, true, "[2, 1]"},
+        {"mean([1, 2, 3])", true, true, "2"},
+        {"mean([1, 2])", true, true, "1.5"},
+        {"mean([0.1, 0.2, 0.3])", true, true, "0.2"},
+        {"variance([1, 2, 3, 4])", true, true, "1.6666666666666667"},
+        {"pvariance([2, 4, 4, 4, 5, 5, 7, 9])", true, true, "4"},
+        {"pstddev([1, 3])", true, true, "1"},
+        {"stddev([1, 3])", true, true, "1.4142135623730951"},
+        {"median([4, 1, 3, 2])", true, true, "2.5"},
+        {"median([1, 3])", true, true, "2"},
+        {"percentile([1, 2, 3, 4, 5], 30)", true, true, "2.2"},
+        {"correlation([1, 2, 3], [2, 4, 7])", true, true, "0.9933992677987828"},
+        {"correlation([1, 2, 3], [2, 4, 6])", true, true, "1"},
+        {"regression([1, 2, 3], [3, 5, 7])", true, true, "[2, 1]"},
+        {"regression([0.1, 0.2, 0.3], [1, 2, 3])", true, true, "[10, 0]"},
+        {"mean([])", false, false, "mean needs at least 1 number, not 0"},
+        {"variance([1,])", false, false, "variance needs at least 2 numbers"},
+        {"mean(1, 2)", false, false, "mean takes 1 list, not 2 arguments"},
+        {"mean(5)", false, false, "cannot apply mean to int"},
+        {"percentile([1, 2], 101)", false, false, "percentile 101 is not from 0 to 100"},
+        {"percentile([1, 2])", false, false, "usage: percentile(list, p)"},
+        {"correlation([1, 2], [1, 2, 3])", false, false, "two lists of the same length"},
+        {"correlation([1, 1], [1, 2])", false, false, "correlation is undefined"},
+        {"regression([1, 1], [1, 2])", true, false, "regression is undefined"},
+        {"mean([1, 2.0^2000])", true, false, "cannot be computed exactly"},
+        {"mean(x) = x", false, false, "cannot redefine built-in function mean"},
+    }
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Rational = testCase.rational
+        result, err := interp.Evaluate()
+        if err != nil {
+            if testCase.shouldPass || !strings.Contains(err.Error(), testCase.expected) {
+                t.Errorf("FAIL: %q: expected %s, got error %v", testCase.input, testCase.expected, err)
+            }
+            continue
+        }
+        if got := format.Value(result, format.Default()); !testCase.shouldPass || got != testCase.expected {
+            t.Errorf("FAIL: %q (rational %v): expected %s, got %s", testCase.input, testCase.rational,
+                testCase.expected, got)
+        }
+    }
+}
+
+func TestComplex(t *testing.T) {
+    testCases := []struct {
+        input      string
+        complex    bool
+        shouldPass bool
+        expected   string // the result as format.Value writes it, or part of the error message
+    }{
+        {"2i", false, true, "2i"},
+        {"0.5i", false, true, "0.5i"},
+        {"i", false, true, "i"},
+        {"-i", false, true, "-i"},
+        {"1 + 2i", false, true, "1 + 2i"},
+        {"3 - 4i", false, true, "3 - 4i"},
+        {"(1 + 2i) * (3 - i)", false, true, "5 + 5i"},
+        {"(1 + 2i) / (1 - 2i)", false, true, "-0.6 + 0.8i"},
+        {"i * i", false, true, "-1"},
+        {"i^2", false, true, "-1"},
+        {"(1 + i)^4", false, true, "-4"},
+        {"i^-1", false, true, "-i"},
+        {"2 - (1 + i)", false, true, "1 - i"},
+        {"(1 + 2i) == 1 + 2i", false, true, "true"},
+        {"2i != 2", false, true, "true"},
+        {"re(3 + 4i)", false, true, "3"},
+        {"im(3 + 4i)", false, true, "4"},
+        {"abs(3 + 4i)", false, true, "5"},
+        {"arg(2i)", false, true, "1.5707963267948966"},
+        {"arg(-1)", false, true, "3.141592653589793"},
+        {"conj(3 + 4i)", false, true, "3 - 4i"},
+        {"re(5)", false, true, "5"},
+        {"im(5)", false, true, "0"},
+        {"sqrt(2i)", false, true, "1 + i"},
+        {"exp(i * pi) + 1", false, true, "1.2246467991473515e-16i"},
+        {"for i in 1..3 { x = i }; x + i", false, true, "3 + i"},
+        {"sqrt(-4)", true, true, "2i"},
+        {"ln(-1)", true, true, "3.141592653589793i"},
+        {"(-4)^0.5", true, true, "1.2246467991473515e-16 + 2i"},
+        {"sqrt(-1)", false, false, "sqrt(-1) has no finite value"},
+        {"(-4)^0.5", false, false, "is undefined"},
+        {"1i < 2i", false, false, "cannot apply < to complex and complex"},
+        {"(1 + i) < 2", false, false, "cannot apply < to complex and int"},
+        {"2.5 >= 3i", false, false, "cannot apply >= to float and complex"},
+        {"2i / 0", false, false, "division by zero"},
+        {"re(true)", false, false, "cannot apply re to bool"},
+        {"[1, i]", false, false, "list element 2 is complex"},
+        {"2i3", false, false, "unexpected IDENT"},
+    }
+    for _, testCase := range testCases {
+        interp := newInterpreter(testCase.input)
+        interp.Complex = testCase.complex
+        result, err := interp.Evaluate()
+        if err != nil {
+            if testCase.shouldPass || !strings.Contains(err.Error(), testCase.expected) {
+                t.Errorf("FAIL: %q: expected %s, got error %v", testCase.input, testCase.expected, err)
+            }
+            continue
+        }
+        if got := format.Value(result, format.Default()); !testCase.shouldPass || got != testCase.expected {
+            t.Errorf("FAIL: %q (complex %v): expected %s, got %s", testCase.input, testCase.complex,
+                testCase.expected, got)
+        }
+    }
+
+    // complex numbers are written to a session file and read back
+    for _, value := range []complex128{complex(1, 2), complex(-0.5, -3)} {
+        text, err := writeValue(value)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if read, err := readValue(text); err != nil || read != value {
+            t.Errorf("FAIL: %v is written as %s and read as %v, %v", value, text, read, err)
+        }
+    }
+
+    // assigning i hides the imaginary unit, which the session notes once
+    s := &session{format: format.Default()}
+    s.clear()
+    for _, testCase := range []struct{ input, note string }{{"for i in 1..3 { }", ""}, {"i = 2", imaginaryNote},
+        {"(1 + i)^2", ""}} {
+        if _, note, err := s.evaluate(testCase.input); err != nil || note != testCase.note {
+            t.Errorf("FAIL: %s: expected note %q, got %q (%v)", testCase.input, testCase.note, note, err)
+        }
+    }
+
+    // a traced complex sum keeps its parentheses
+    tracer := interpreter.NewTracer(newInterpreter("(1 + i) * 2 * i"))
+    tracer.Format = func(value interface{}) string { return format.Value(value, format.Default()) }
+    if _, err := tracer.Evaluate(); err != nil {
+        t.Fatal(err)
+    }
+    if lines := strings.Join(tracer.Lines(), "\n"); !strings.Contains(lines, "=  (2 + 2i) * i\n=  -2 + 2i") {
+        t.Errorf("FAIL: trace of (1 + i) * 2 * i:\n%s", lines)
+    }
+}
+
+func TestUnits(t *testing.T) {
+    testCases := []struct {
+        input      string
+        shouldPass bool
+        expected   string // the result as format.Value writes it, or part of the error message
+    }{
+        {"5 km", true, "5 km"},
+        {"5 km / 2 h", true, "2.5 km/h"},
+        {"5 km/h", true, "5 km/h"},
+        {"9.8 m/s^2", true, "9.8 m/s^2"},
+        {"3 m^2", true, "3 m^2"},
+        {"(3 m)^2", true, "9 m^2"},
+        {"2 m * 3 m", true, "6 m^2"},
+        {"1 kg m/s^2 to N", true, "1 N"},
+        {"2 s^-1", true, "2 s^-1"},
+        {"1 km + 500 m", true, "1.5 km"},
+        {"1 h - 30 min", true, "0.5 h"},
+        {"3 ft to m", true, "0.9144 m"},
+        {"100 km/h to m/s", true, "27.77777777777778 m/s"},
+        {"(5 km / 2 h) to km/min", true, "0.041666666666666664 km/min"},
+        {"5 km / 2 m", true, "2500"},
+        {"6 km / 2 km", true, "3"},
+        {"2 h * 3", true, "6 h"},
+        {"10 m / 4", true, "2.5 m"},
+        {"-5 km", true, "-5 km"},
+        {"1 km > 999 m", true, "true"},
+        {"1 km == 1000 m", true, "true"},
+        {"x = 5 km; x to m", true, "5000 m"},
+        {"1 h to s", true, "3600 s"},
+        {"1 kWh to J", true, "3.6e+06 J"},
+        {"2 min(1, 2)", false, "unexpected"},
+        {"1 m + 1 s", false, "cannot add m and s at column 5"},
+        {"1 m - 1", false, "cannot subtract m and a number"},
+        {"1 m < 1 kg", false, "cannot compare m and kg"},
+        {"3 ft to s", false, "cannot convert ft to s"},
+        {"3 to m", false, "cannot convert a number to m"},
+        {"3 m to parsec", false, "unknown unit parsec"},
+        {"3 m to", false, "expected a unit but received EOF"},
+        {"(2 m)^0.5", false, "cannot raise m to the power 0.5"},
+        {"2^(1 s)", false, "exponent 1 s has a unit"},
+        {"2^3 m", true, "8 m"},                           // the unit belongs to the whole power
+        {"10^3 m to km", true, "1 km"},
+        {"1 m / 0", false, "division by zero"},
+        {"1 m && true", false, "type error"},
+    }
+    for _, testCase := range testCases {
+        result, err := newInterpreter(testCase.input).Evaluate()
+        if err != nil {
+            if testCase.shouldPass || !strings.Contains(err.Error(), testCase.expected) {
+                t.Errorf("FAIL: %q: expected %s, got error %v", testCase.input, testCase.expected, err)
+            }
+            continue
+        }
+        if got := format.Value(result, format.Default()); !testCase.shouldPass || got != testCase.expected {
+            t.Errorf("FAIL: %q: expected %s, got %s", testCase.input, testCase.expected, got)
+        }
+    }
+
+    // a '/' between spaces divides, so a variable named like a unit can still be divided by
+    if result, err := newInterpreter("mi = 2; 10 m / mi").Evaluate(); err != nil ||
+        format.Value(result, format.Default()) != "5 m" {
+        t.Errorf("FAIL: 10 m / mi with mi = 2: expected 5 m, got %v, %v", result, err)
+    }
+
+    // with implicit multiplication a unit right after a number that is also a variable or constant is ambiguous
+    implicitCases := []struct {
+        input    string
+        expected string // the result, or part of the error message
+    }{
+        {"2m", "2 m"},
+        {"m = 4; 2m", "2m is ambiguous, m is a unit and a variable"},
+        {"m = 4; 2 m", "2 m"},
+        {"m = 4; 2 * m", "8"},
+        {"2g", "2 g"},                                     // a unit named like a constant is the unit
+        {"2 g", "2 g"},
+        {"2 * g", "19.6133"},
+        {"x = 3; 2x", "6"},
+    }
+    for _, testCase := range implicitCases {
+        interp := newInterpreter(testCase.input)
+        interp.Parser.ImplicitMul = true
+        result, err := interp.Evaluate()
+        got := fmt.Sprint(err)
+        if err == nil {
+            got = format.Value(result, format.Default())
+        }
+        if !strings.Contains(got, testCase.expected) {
+            t.Errorf("FAIL: %q with implicit multiplication: expected %s, got %s", testCase.input, testCase.expected, got)
+        }
+    }
+
+    // written out and parsed again, measures and conversions give the same tree
+    for _, input := range []string{"5 km / 2 h", "(3 m)^2", "3 m^2", "-2 s^-1", "c ? 1 m : 2 m to cm",
+        "(c ? 1 m : 2 m) to cm", "(1 ft to m) to cm", "9.8 m/s^2 * 2 kg to N", "2^3 m", "2^(3 m)"} {
+        first := interpreter.Source(parse(t, input))
+        if second := interpreter.Source(parse(t, first)); first != second {
+            t.Errorf("FAIL: %s is written as %s, which is written as %s", input, first, second)
+        }
+    }
+
+    // quantities are written to a session file and read back
+    value := &interpreter.Quantity{Value: 2.5, Unit: units.Unit{{Name: "km", Exponent: 1}, {Name: "h", Exponent: -1}}}
+    text, err := writeValue(value)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if read, err := readValue(text); err != nil || format.Value(read, format.Default()) != "2.5 km/h" {
+        t.Errorf("FAIL: %v is written as %s and read as %v, %v", value, text, read, err)
+    }
+}
@@ -2,7 +2,9 @@ package nestingstack
 
 /*
 
-Used by the parser to ensure parentheses are balanced. 
+Used by the parser to ensure brackets are balanced: ( ), [ ] and { }. The parser pushes each opening bracket
+token and closes it with Close, which reports a closing bracket of another kind than the innermost open
+one as a MismatchError naming the positions of both.
 
 */
 
@@ -11,6 +13,50 @@ import (
     "fmt"
 )
 
+const (
+    LPAR     = "LPAR"
+    RPAR     = "RPAR"
+    LBRACKET = "LBRACKET"
+    RBRACKET = "RBRACKET"
+    LBRACE   = "LBRACE"
+    RBRACE   = "RBRACE"
+    EOF      = "EOF"
+)
+
+// opening maps each closing bracket type to the opening one it closes 
+var opening = map[string]string{RPAR: LPAR, RBRACKET: LBRACKET, RBRACE: LBRACE}
+
+// IsOpening reports whether a token type is an opening bracket, and IsClosing a closing one 
+func IsOpening(tokenType string) bool {
+    return tokenType == LPAR || tokenType == LBRACKET || tokenType == LBRACE
+}
+
+func IsClosing(tokenType string) bool {
+    _, ok := opening[tokenType]
+    return ok
+}
+
+// MismatchError is a closing bracket that does not close the innermost open bracket. Open is the innermost
+// open bracket, with an empty type if there is none, and Close the closing bracket, or the EOF token when the
+// input ends with Open still open. 
+type MismatchError struct {
+    Open  token.Token
+    Close token.Token
+}
+
+func (e *MismatchError) Error() string {
+    switch {
+    case e.Open.TokenType == "":
+        return fmt.Sprintf("nestingstack: unexpected '%s' at column %d, no bracket is open", e.Close.Literal,
+            e.Close.Position + 1)
+    case e.Close.TokenType == EOF:
+        return fmt.Sprintf("nestingstack: '%s' at column %d is never closed", e.Open.Literal, e.Open.Position + 1)
+    default:
+        return fmt.Sprintf("nestingstack: mismatched brackets: '%s' at column %d is closed by '%s' at column %d",
+            e.Open.Literal, e.Open.Position + 1, e.Close.Literal, e.Close.Position + 1)
+    }
+}
+
 type NestingStack struct {
     Stack []token.Token
 }
@@ -53,3 +99,28 @@ func (ns *NestingStack) Peek() (token.Token, error) {
 func(ns *NestingStack) Push(value token.Token) {
     ns.Stack = append(ns.Stack, value)
 }
+
+// Match checks that a closing bracket, or the end of the input, closes the innermost open bracket (nothing
+// may be open at the end), without removing it 
+func (ns *NestingStack) Match(closing token.Token) error {
+    open, err := ns.Peek()
+    if err != nil {
+        if closing.TokenType == EOF {
+            return nil
+        }
+        return &MismatchError{Close: closing}
+    }
+    if opening[closing.TokenType] != open.TokenType {
+        return &MismatchError{Open: open, Close: closing}
+    }
+    return nil
+}
+
+// Close removes the innermost open bracket, which the closing bracket must close 
+func (ns *NestingStack) Close(closing token.Token) error {
+    if err := ns.Match(closing); err != nil {
+        return err
+    }
+    _, err := ns.Pop()
+    return err
+}
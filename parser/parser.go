@@ -10,6 +10,7 @@ import (
     "calculator/lexer"
     "calculator/token"
     "calculator/nestingstack"
+    "calculator/units"
     "fmt"
 )
 
@@ -22,17 +23,67 @@ const (
     LPAR    = "LPAR"
     RPAR    = "RPAR"
     EOF     = "EOF"
+    BOOLEAN = "BOOLEAN"
+    EQ      = "EQ"
+    NE      = "NE"
+    LT      = "LT"
+    LE      = "LE"
+    GT      = "GT"
+    GE      = "GE"
+    AND     = "AND"
+    OR      = "OR"
+    NOT     = "NOT"
+    IDENT   = "IDENT"
+    QUESTION = "QUESTION"
+    COLON   = "COLON"
+    IF      = "IF"
+    THEN    = "THEN"
+    ELSE    = "ELSE"
+    SEMI    = "SEMI"
+    LBRACE  = "LBRACE"
+    RBRACE  = "RBRACE"
+    ASSIGN  = "ASSIGN"
+    DOTDOT  = "DOTDOT"
+    WHILE   = "WHILE"
+    FOR     = "FOR"
+    IN      = "IN"
+    BREAK   = "BREAK"
+    BITAND  = "BITAND"
+    BITOR   = "BITOR"
+    CARET   = "CARET"
+    TILDE   = "TILDE"
+    SHL     = "SHL"
+    SHR     = "SHR"
+    FLOAT   = "FLOAT"
+    PERCENT = "PERCENT"
+    FACTORIAL = "FACTORIAL"
+    HISTORY = "HISTORY"
+    COMMA   = "COMMA"
+    POWER   = "POWER"
+    LBRACKET = "LBRACKET"
+    RBRACKET = "RBRACKET"
+    DOTMUL  = "DOTMUL"
+    IMAGINARY = "IMAGINARY"
+    TO      = "TO"
 )
 
 type Parser struct {
     Lex *lexer.Lexer
     CurrentToken *token.Token
     Stack *nestingstack.NestingStack
+    Programmer bool // programmer mode: '^' is bitwise xor rather than the power operator
+    ImplicitMul bool // juxtaposed factors are multiplied: 2(3+4), (1+2)(3+4), 2x
+    previous *token.Token // the token consumed last
+    system bool // inside solve {...}, where a name after a number is an unknown rather than a unit
+    loops int // number of loops enclosing the current token, break is only allowed inside one 
 }
 
 func NewParser(lex *lexer.Lexer) (*Parser, error) {
     currentToken, err := lex.GetNextToken()
     stack := nestingstack.NewNestingStack() 
+    if err == nil && nestingstack.IsClosing(currentToken.TokenType) {
+        err = stack.Match(*currentToken) // a closing bracket before anything is opened
+    }
     return &Parser{Lex: lex, CurrentToken: currentToken, Stack: stack}, err
 }
 
@@ -47,51 +98,57 @@ func (p *Parser) Consume(expectedType string) error {
             expectedType, p.CurrentToken.TokenType)
     }
 
-    // if current token is LPAR then push an LPAR to the nesting stack 
-    if p.CurrentToken.TokenType == LPAR {
-        p.Stack.Push(token.Token{LPAR, '('})
+    // an opening bracket is pushed to the nesting stack, a closing one pops the bracket it closes 
+    if nestingstack.IsOpening(p.CurrentToken.TokenType) {
+        p.Stack.Push(*p.CurrentToken)
     }
-    // if current token is RPAR, then pop an LPAR from the nesting stack
-    if p.CurrentToken.TokenType == RPAR {
-        t, err := p.Stack.Peek() 
-        if err != nil {
-            return fmt.Errorf("parser.Parse(): unexpected ')'") // missing opening '('
-        }
-        if t.TokenType != LPAR {
-            return fmt.Errorf("parser.Parse(): invalid character on stack") 
-        }
-        t, err = p.Stack.Pop()
-        if err != nil {
+    if nestingstack.IsClosing(p.CurrentToken.TokenType) {
+        if err := p.Stack.Close(*p.CurrentToken); err != nil {
             return err
         }
     }
     previousToken := p.CurrentToken // save current token before getting next token
+    p.previous = previousToken
     var err error
     p.CurrentToken, err = p.Lex.GetNextToken()
     if err != nil {
         return err
     }
+    // newlines only separate statements outside of parentheses, inside them they are white space 
+    for p.CurrentToken.TokenType == SEMI && p.CurrentToken.Value == '\n' && p.insideParentheses() {
+        p.CurrentToken, err = p.Lex.GetNextToken()
+        if err != nil {
+            return err
+        }
+    }
 
-    // check if an RPAR was read without a matching LPAR (nesting stack is empty)
-    if p.CurrentToken.TokenType == RPAR {
-        if p.Stack.IsEmpty() {
-            return fmt.Errorf("parser.Parse(): unexpected ')'")
+    // check that a closing bracket closes the innermost open one, and that none is left open at the end, as
+    // soon as it is read: (1 + 2] is a mismatch, not a missing ')'
+    if nestingstack.IsClosing(p.CurrentToken.TokenType) || p.CurrentToken.TokenType == EOF {
+        if err := p.Stack.Match(*p.CurrentToken); err != nil {
+            return err
         }
     }
     // check for integers separated by white space 
-    if previousToken.TokenType == INTEGER {
-        if p.CurrentToken.TokenType == INTEGER {
+    if previousToken.TokenType == INTEGER || previousToken.TokenType == FLOAT || previousToken.TokenType == IMAGINARY {
+        if p.CurrentToken.TokenType == INTEGER || p.CurrentToken.TokenType == FLOAT || p.CurrentToken.TokenType == IMAGINARY {
             return fmt.Errorf("parser.Consume(): syntax error: missing op between integers")
         }
     }
     return nil // all tests passed
 }
 
+// insideParentheses reports whether the innermost open bracket is a '(' 
+func (p *Parser) insideParentheses() bool {
+    t, err := p.Stack.Peek()
+    return err == nil && t.TokenType == LPAR
+}
+
 
-// Factor(): returns an ASTNode of type: UnaryOperation, INTEGER, or an Expr() subtree
+// Factor(): returns an ASTNode of type: UnaryOperation, INTEGER, FLOAT, IMAGINARY, BOOLEAN, IDENT, HISTORY, Conditional, Block, or a Ternary() subtree
 func (p *Parser) Factor() (ast.ASTNode, error) {
    
-    // factor: (PLUS| MINUS)|LPAR expr RPAR|INTEGER
+    // factor: (PLUS|MINUS|NOT|TILDE)power|LPAR ternary RPAR|INTEGER|FLOAT|IMAGINARY|BOOLEAN|IDENT|call|system|matrix|HISTORY|IF ternary THEN ternary ELSE ternary|LBRACE statementList RBRACE
     token := p.CurrentToken  
     switch token.TokenType {
     case PLUS:
@@ -99,7 +156,7 @@ func (p *Parser) Factor() (ast.ASTNode, error) {
         if err := p.Consume(PLUS); err != nil {
             return ast.NewErrorNode(err), err // consume failed, returns error node and error 
         }
-        unaryChild, err := p.Factor() // expression following the unary operation
+        unaryChild, err := p.Power() // expression following the unary operation
         if err != nil {
             return ast.NewErrorNode(err), err
         }
@@ -111,13 +168,132 @@ func (p *Parser) Factor() (ast.ASTNode, error) {
         if err := p.Consume(MINUS); err != nil {
             return ast.NewErrorNode(err), err
         }
-        unaryChild, err := p.Factor() 
+        unaryChild, err := p.Power() 
         if err != nil {
             return ast.NewErrorNode(err),err
         }
         unaryNode := ast.NewUnaryOperation(token, unaryChild)
         return unaryNode, nil
 
+    case NOT:
+        // unary operation: ! 
+        if err := p.Consume(NOT); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        unaryChild, err := p.Power() 
+        if err != nil {
+            return ast.NewErrorNode(err),err
+        }
+        unaryNode := ast.NewUnaryOperation(token, unaryChild)
+        return unaryNode, nil
+
+    case TILDE:
+        // unary operation: ~ (bitwise complement)
+        if err := p.Consume(TILDE); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        unaryChild, err := p.Power() 
+        if err != nil {
+            return ast.NewErrorNode(err),err
+        }
+        unaryNode := ast.NewUnaryOperation(token, unaryChild)
+        return unaryNode, nil
+
+    case BOOLEAN:
+        if err := p.Consume(BOOLEAN); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        booleanNode, err := ast.NewBooleanLiteral(token)
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return booleanNode, nil
+
+    case LBRACE:
+        // { statementList } 
+        if err := p.Consume(LBRACE); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        block, err := p.StatementList(token, RBRACE)
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        if err := p.Consume(RBRACE); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return block, nil
+
+    case IF:
+        // if ternary then ternary else ternary 
+        if err := p.Consume(IF); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        condition, err := p.Ternary()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        if err := p.Consume(THEN); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        consequent, err := p.Ternary()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        if err := p.Consume(ELSE); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        alternative, err := p.Ternary()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return ast.NewConditional(token, condition, consequent, alternative), nil
+
+    case IDENT:
+        if err := p.Consume(IDENT); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        if p.CurrentToken.TokenType == LPAR {
+            return p.Call(token)
+        }
+        if token.Value == "solve" && p.CurrentToken.TokenType == LBRACE {
+            return p.System(token)
+        }
+        variableNode, err := ast.NewVariable(token)
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return variableNode, nil
+
+    case HISTORY:
+        if err := p.Consume(HISTORY); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        historyNode, err := ast.NewHistoryReference(token)
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return historyNode, nil
+
+    case FLOAT:
+        if err := p.Consume(FLOAT); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        floatNode, err := ast.NewFloatLiteral(token)
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return p.Measure(token, floatNode)
+
+    case IMAGINARY:
+        if err := p.Consume(IMAGINARY); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        imaginaryNode, err := ast.NewImaginaryLiteral(token)
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return imaginaryNode, nil
+
     case INTEGER: 
         if err := p.Consume(INTEGER); err != nil {
             return ast.NewErrorNode(err), err
@@ -127,16 +303,19 @@ func (p *Parser) Factor() (ast.ASTNode, error) {
         if err != nil {
             return ast.NewErrorNode(err), err // if type assertion fails 
         }
-        // token was correct type and type assertion passed, return integer node 
-        return integerNode, nil
+        // token was correct type and type assertion passed, return integer node, or a measure if it has a unit
+        return p.Measure(token, integerNode)
      
+    case LBRACKET:
+        return p.Matrix()
+
     case LPAR:
          // ( expr ) 
         if err := p.Consume(LPAR); err != nil {
             return ast.NewErrorNode(err), err
         }
         // get the expression within the ()'s
-        subTreeRoot, err := p.Expr()
+        subTreeRoot, err := p.Ternary()
         if err != nil {
             return ast.NewErrorNode(err), err
         }
@@ -154,32 +333,302 @@ func (p *Parser) Factor() (ast.ASTNode, error) {
 }
 
 
+// Call(): returns a Call node for the function named by the IDENT token just consumed
+func (p *Parser) Call(name *token.Token) (ast.ASTNode, error) {
+
+    // call: IDENT LPAR (argument (COMMA argument)*)? RPAR, argument: ternary (ASSIGN ternary)?
+    if err := p.Consume(LPAR); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    arguments := make([]ast.ASTNode, 0)
+    for p.CurrentToken.TokenType != RPAR {
+        if len(arguments) > 0 {
+            if err := p.Consume(COMMA); err != nil {
+                return ast.NewErrorNode(err), err
+            }
+        }
+        argument, err := p.Ternary()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        if p.CurrentToken.TokenType == ASSIGN {
+            // an equation, for solve()
+            equalsToken := p.CurrentToken
+            if err := p.Consume(ASSIGN); err != nil {
+                return ast.NewErrorNode(err), err
+            }
+            right, err := p.Ternary()
+            if err != nil {
+                return ast.NewErrorNode(err), err
+            }
+            argument = ast.NewEquation(equalsToken, argument, right)
+        }
+        arguments = append(arguments, argument)
+    }
+    if err := p.Consume(RPAR); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    return ast.NewCall(name, name.Value.(string), arguments), nil
+}
+
+// System(): returns a Call of solve with one argument, the Block of a system of equations: solve {2x + y = 5;
+// x - y = 1}. The equations are separated like statements. 
+func (p *Parser) System(name *token.Token) (ast.ASTNode, error) {
+
+    // system: IDENT LBRACE SEMI* (equation (SEMI+ equation)* SEMI*)? RBRACE, equation: ternary ASSIGN ternary
+    // equations are written the way they are on paper, 2x + y = 5, whatever the implicit multiplication setting
+    implicit, system := p.ImplicitMul, p.system
+    p.ImplicitMul, p.system = true, true
+    defer func() { p.ImplicitMul, p.system = implicit, system }()
+    start := p.CurrentToken
+    if err := p.Consume(LBRACE); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    equations := make([]ast.ASTNode, 0)
+    for p.CurrentToken.TokenType != RBRACE {
+        if p.CurrentToken.TokenType == SEMI {
+            if err := p.Consume(SEMI); err != nil {
+                return ast.NewErrorNode(err), err
+            }
+            continue
+        }
+        left, err := p.Ternary()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        equalsToken := p.CurrentToken
+        if err := p.Consume(ASSIGN); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        right, err := p.Ternary()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        equations = append(equations, ast.NewEquation(equalsToken, left, right))
+        if p.CurrentToken.TokenType != SEMI && p.CurrentToken.TokenType != RBRACE {
+            err := fmt.Errorf("parser.System(): unexpected %s at end of equation", p.CurrentToken.TokenType)
+            return ast.NewErrorNode(err), err
+        }
+    }
+    if err := p.Consume(RBRACE); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    return ast.NewCall(name, name.Value.(string), []ast.ASTNode{ast.NewBlock(start, equations)}), nil
+}
+
+// Matrix(): returns a MatrixLiteral, [1, 2; 3, 4], or a ListLiteral, [1, 2, 3]. Rows are separated by ';'
+// or a newline and must all have the same number of elements; brackets without a separator in them hold a 
+// list, so [1, 2;] is a matrix of one row and [] the empty list. A single expression in brackets is grouped
+// like one in parentheses, [1 + 2] is 3: a list of one element is written with a trailing comma, [3,]. 
+func (p *Parser) Matrix() (ast.ASTNode, error) {
+
+    // matrix: LBRACKET SEMI* row (SEMI+ row)* SEMI* RBRACKET, row: ternary (COMMA ternary)* COMMA?
+    start := p.CurrentToken
+    if err := p.Consume(LBRACKET); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    rows := make([][]ast.ASTNode, 0)
+    separated := false
+    trailing := false // a comma before the closing bracket, [3,]
+    for p.CurrentToken.TokenType != RBRACKET {
+        if p.CurrentToken.TokenType == SEMI {
+            if err := p.Consume(SEMI); err != nil {
+                return ast.NewErrorNode(err), err
+            }
+            separated = true
+            continue
+        }
+        rowStart := p.CurrentToken
+        row := make([]ast.ASTNode, 0)
+        for {
+            element, err := p.Ternary()
+            if err != nil {
+                return ast.NewErrorNode(err), err
+            }
+            row = append(row, element)
+            if p.CurrentToken.TokenType != COMMA {
+                break
+            }
+            if err := p.Consume(COMMA); err != nil {
+                return ast.NewErrorNode(err), err
+            }
+            if p.CurrentToken.TokenType == RBRACKET {
+                trailing = true
+                break
+            }
+        }
+        if len(rows) > 0 && len(row) != len(rows[0]) {
+            err := fmt.Errorf("parser.Matrix(): row %d has %d elements, row 1 has %d at column %d", len(rows) + 1,
+                len(row), len(rows[0]), rowStart.Position + 1)
+            return ast.NewErrorNode(err), err
+        }
+        rows = append(rows, row)
+        if p.CurrentToken.TokenType != SEMI && p.CurrentToken.TokenType != RBRACKET {
+            err := fmt.Errorf("parser.Matrix(): unexpected %s in a matrix at column %d", p.CurrentToken.TokenType,
+                p.CurrentToken.Position + 1)
+            return ast.NewErrorNode(err), err
+        }
+    }
+    if err := p.Consume(RBRACKET); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    switch {
+    case len(rows) == 0 && !separated:
+        return ast.NewListLiteral(start, nil), nil
+    case len(rows) == 0:
+        err := fmt.Errorf("parser.Matrix(): empty matrix at column %d", start.Position + 1)
+        return ast.NewErrorNode(err), err
+    case len(rows) == 1 && len(rows[0]) == 1 && !separated && !trailing:
+        return rows[0][0], nil // grouped
+    case len(rows) == 1 && !separated:
+        return ast.NewListLiteral(start, rows[0]), nil
+    }
+    return ast.NewMatrixLiteral(start, rows), nil
+}
+
+// Power(): returns an ASTNode: a subtree with POWER as the root, or a Postfix() subtree. Outside programmer
+// mode '^' raises to a power. It is right associative (2^3^2 is 2^9) and binds tighter than a prefix operator
+// (the operand of which is parsed with Power(), so -2^2 is -(2^2)), but not as tight as a postfix one. 
+func (p *Parser) Power() (ast.ASTNode, error) {
+
+    // power: postfix (CARET power)?, where the exponent may start with a prefix operator (2^-1)
+    base, err := p.Postfix()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    if p.CurrentToken.TokenType != CARET || p.Programmer {
+        return base, nil
+    }
+    operator := *p.CurrentToken
+    if err := p.Consume(CARET); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    operator.TokenType = POWER
+    grouped := p.CurrentToken.TokenType == LPAR
+    var exponent ast.ASTNode
+    switch p.CurrentToken.TokenType {
+    case PLUS, MINUS, NOT, TILDE:
+        exponent, err = p.Factor()
+    default:
+        exponent, err = p.Power()
+    }
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    // a unit after the exponent belongs to the whole power: 2^3 m is 8 m, not 2 to the power 3 m, while 2^(3 m)
+    // is still an error
+    if measure, ok := exponent.(*ast.Measure); ok && !grouped {
+        return ast.NewMeasure(measure.Token, ast.NewBinaryOperation(base, measure.Value, &operator), measure.Unit,
+            measure.Attached), nil
+    }
+    return ast.NewBinaryOperation(base, exponent, &operator), nil
+}
+
+// Postfix(): returns an ASTNode: a postfix UnaryOperation (FACTORIAL or PERCENT) or a Factor(). The operand of
+// a prefix operator is parsed with Power(), so -3! is -(3!). 
+func (p *Parser) Postfix() (ast.ASTNode, error) {
+
+    // postfix: factor(NOT|PERCENT|index)*, where a NOT after a factor is a factorial, 
+    // index: LBRACKET ternary (COMMA ternary)* RBRACKET
+    node, err := p.Factor()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    for p.CurrentToken.TokenType == NOT || p.CurrentToken.TokenType == PERCENT || p.CurrentToken.TokenType == LBRACKET {
+        if p.CurrentToken.TokenType == LBRACKET {
+            // an element of a list or matrix: xs[1], m[2, 1]
+            token := p.CurrentToken
+            if err := p.Consume(LBRACKET); err != nil {
+                return ast.NewErrorNode(err), err
+            }
+            indices := make([]ast.ASTNode, 0)
+            for len(indices) == 0 || p.CurrentToken.TokenType == COMMA {
+                if len(indices) > 0 {
+                    if err := p.Consume(COMMA); err != nil {
+                        return ast.NewErrorNode(err), err
+                    }
+                }
+                index, err := p.Ternary()
+                if err != nil {
+                    return ast.NewErrorNode(err), err
+                }
+                indices = append(indices, index)
+            }
+            if err := p.Consume(RBRACKET); err != nil {
+                return ast.NewErrorNode(err), err
+            }
+            node = ast.NewIndex(token, node, indices)
+            continue
+        }
+        operator := *p.CurrentToken
+        if err := p.Consume(operator.TokenType); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        if operator.TokenType == NOT {
+            operator.TokenType = FACTORIAL
+        }
+        node = ast.NewPostfixOperation(&operator, node)
+    }
+    return node, nil
+}
+
+// implicitMul reports whether, in implicit multiplication mode, the current token starts a factor that is
+// multiplied by the one just parsed: a number or ')' followed by '(', or a number followed by a name. Two
+// numbers in a row are still an error (see Consume()). 
+func (p *Parser) implicitMul() bool {
+    if !p.ImplicitMul || p.previous == nil {
+        return false
+    }
+    switch p.previous.TokenType {
+    case INTEGER, FLOAT, IMAGINARY:
+        return p.CurrentToken.TokenType == LPAR || p.CurrentToken.TokenType == IDENT
+    case RPAR:
+        return p.CurrentToken.TokenType == LPAR
+    }
+    return false
+}
+
+// implicitMulToken returns the MUL token of an implicit multiplication, placed where the second factor starts
+func implicitMulToken(position int) *token.Token {
+    t := token.NewToken(MUL, '*')
+    t.Position = position
+    return t
+}
+
 // Term(): returns an ASTNode: a subtree with MUL or DIV as the root, an INTEGER leaf node, or UnaryOp
 func (p *Parser) Term() (ast.ASTNode, error) {
 
-    // term: factor((MUL|DIV)factor)*
-    leftChild, err := p.Factor()  
+    // term: power((MUL|DIV|DOTMUL)?power)*, the operator may only be left out in implicit multiplication mode
+    leftChild, err := p.Power()  
     if err != nil {
         return ast.NewErrorNode(err), err 
     }
-    for p.CurrentToken.TokenType == MUL || p.CurrentToken.TokenType == DIV { 
+    for p.CurrentToken.TokenType == MUL || p.CurrentToken.TokenType == DIV || p.CurrentToken.TokenType == DOTMUL ||
+        p.implicitMul() { 
         token := p.CurrentToken
 
         // get operation type 
-        switch p.CurrentToken.TokenType {
-        case MUL:
+        switch {
+        case p.implicitMul():
+            // no operator to consume, the next factor follows straight on
+            token = implicitMulToken(p.CurrentToken.Position)
+        case p.CurrentToken.TokenType == MUL:
             if err := p.Consume(MUL); err != nil { 
                 return ast.NewErrorNode(err), err
             }
-        case DIV:
+        case p.CurrentToken.TokenType == DIV:
             if err := p.Consume(DIV); err != nil {
                 return ast.NewErrorNode(err), err
             }
+        case p.CurrentToken.TokenType == DOTMUL:
+            if err := p.Consume(DOTMUL); err != nil {
+                return ast.NewErrorNode(err), err
+            }
         default:
             return ast.NewErrorNode(err), fmt.Errorf("parser.Term() reached default case")
         }
         // get rightChild (integer leaf node or addition/subtraction subtree)
-        rightChild, err := p.Factor()
+        rightChild, err := p.Power()
         if err != nil {
             return ast.NewErrorNode(err), err
         }
@@ -223,28 +672,544 @@ func (p *Parser) Expr() (ast.ASTNode, error) {
     return leftChild, nil 
 }
 
-// final return point to Interpreter: returns root of AST to interpreter 
-func (p *Parser) Parse() (ast.ASTNode, error) {
-    rootNode, err := p.Expr()
+// Shift(): returns an ASTNode: a subtree with SHL or SHR as the root, or an Expr() subtree
+func (p *Parser) Shift() (ast.ASTNode, error) {
+
+    // shift: expr((SHL|SHR)expr)*
+    leftChild, err := p.Expr()
     if err != nil {
         return ast.NewErrorNode(err), err
     }
+    for p.CurrentToken.TokenType == SHL || p.CurrentToken.TokenType == SHR {
+        token := p.CurrentToken
+        if err := p.Consume(token.TokenType); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        rightChild, err := p.Expr()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        leftChild = ast.NewBinaryOperation(leftChild, rightChild, token)
+    }
+    return leftChild, nil
+}
 
-   // ~~ for debugging ~~ 
-   // fmt.Println(rootNode)
-   // fmt.Printf("parser.Parse(): return stack size: %d\n",parser.Stack.StackSize())
-   // fmt.Printf("parser.Parse(): return token:")    
+// Relational(): returns an ASTNode: a subtree with LT, LE, GT or GE as the root, or a Shift() subtree
+func (p *Parser) Relational() (ast.ASTNode, error) {
+
+    // relational: shift((LT|LE|GT|GE)shift)*
+    leftChild, err := p.Shift()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    for p.CurrentToken.TokenType == LT || p.CurrentToken.TokenType == LE ||
+        p.CurrentToken.TokenType == GT || p.CurrentToken.TokenType == GE {
+        token := p.CurrentToken
+        if err := p.Consume(token.TokenType); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        rightChild, err := p.Shift()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        leftChild = ast.NewBinaryOperation(leftChild, rightChild, token)
+    }
+    return leftChild, nil
+}
+
+// Equality(): returns an ASTNode: a subtree with EQ or NE as the root, or a Relational() subtree
+func (p *Parser) Equality() (ast.ASTNode, error) {
+
+    // equality: relational((EQ|NE)relational)*
+    leftChild, err := p.Relational()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    for p.CurrentToken.TokenType == EQ || p.CurrentToken.TokenType == NE {
+        token := p.CurrentToken
+        if err := p.Consume(token.TokenType); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        rightChild, err := p.Relational()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        leftChild = ast.NewBinaryOperation(leftChild, rightChild, token)
+    }
+    return leftChild, nil
+}
+
+// BitAnd(): returns an ASTNode: a subtree with BITAND as the root, or an Equality() subtree
+func (p *Parser) BitAnd() (ast.ASTNode, error) {
+
+    // bitAnd: equality(BITAND equality)*
+    leftChild, err := p.Equality()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    for p.CurrentToken.TokenType == BITAND {
+        token := p.CurrentToken
+        if err := p.Consume(BITAND); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        rightChild, err := p.Equality()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        leftChild = ast.NewBinaryOperation(leftChild, rightChild, token)
+    }
+    return leftChild, nil
+}
+
+// BitXor(): returns an ASTNode: a subtree with CARET (xor) as the root, or a BitAnd() subtree. '^' is only
+// xor in programmer mode, otherwise Power() has already taken it. 
+func (p *Parser) BitXor() (ast.ASTNode, error) {
+
+    // bitXor: bitAnd(CARET bitAnd)*
+    leftChild, err := p.BitAnd()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    for p.CurrentToken.TokenType == CARET {
+        token := p.CurrentToken
+        if err := p.Consume(CARET); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        rightChild, err := p.BitAnd()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        leftChild = ast.NewBinaryOperation(leftChild, rightChild, token)
+    }
+    return leftChild, nil
+}
+
+// BitOr(): returns an ASTNode: a subtree with BITOR as the root, or a BitXor() subtree
+func (p *Parser) BitOr() (ast.ASTNode, error) {
+
+    // bitOr: bitXor(BITOR bitXor)*
+    leftChild, err := p.BitXor()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    for p.CurrentToken.TokenType == BITOR {
+        token := p.CurrentToken
+        if err := p.Consume(BITOR); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        rightChild, err := p.BitXor()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        leftChild = ast.NewBinaryOperation(leftChild, rightChild, token)
+    }
+    return leftChild, nil
+}
+
+// And(): returns an ASTNode: a LogicalOperation subtree with AND as the root, or a BitOr() subtree
+func (p *Parser) And() (ast.ASTNode, error) {
+
+    // and: bitOr(AND bitOr)*
+    leftChild, err := p.BitOr()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    for p.CurrentToken.TokenType == AND {
+        token := p.CurrentToken
+        if err := p.Consume(AND); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        rightChild, err := p.BitOr()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        leftChild = ast.NewLogicalOperation(leftChild, rightChild, token)
+    }
+    return leftChild, nil
+}
+
+// Or(): returns an ASTNode: a LogicalOperation subtree with OR as the root, or an And() subtree
+func (p *Parser) Or() (ast.ASTNode, error) {
+
+    // or: and(OR and)*
+    leftChild, err := p.And()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    for p.CurrentToken.TokenType == OR {
+        token := p.CurrentToken
+        if err := p.Consume(OR); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        rightChild, err := p.And()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        leftChild = ast.NewLogicalOperation(leftChild, rightChild, token)
+    }
+    return leftChild, nil
+}
+
+// Ternary(): returns an ASTNode: a Conditional subtree, or an Or() subtree. This is the lowest precedence
+// level, so it parses a complete expression. The branches are parsed with Ternary() again, so 
+// a ? b : c ? d : e groups as a ? b : (c ? d : e).
+func (p *Parser) Ternary() (ast.ASTNode, error) {
+
+    // ternary: range(QUESTION ternary COLON ternary)?(TO units)?
+    condition, err := p.Range()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    if p.CurrentToken.TokenType != QUESTION {
+        return p.Conversion(condition)
+    }
+    token := p.CurrentToken
+    if err := p.Consume(QUESTION); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    consequent, err := p.Ternary()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    if err := p.Consume(COLON); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    alternative, err := p.Ternary()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    return p.Conversion(ast.NewConditional(token, condition, consequent, alternative))
+}
+
+// Conversion(): returns a Conversion node, expr to unit (3 ft to m), or expr if no unit follows
+func (p *Parser) Conversion(expr ast.ASTNode) (ast.ASTNode, error) {
+    if p.CurrentToken.TokenType != TO {
+        return expr, nil
+    }
+    token := p.CurrentToken
+    if err := p.Consume(TO); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    unit, err := p.Units()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    return ast.NewConversion(token, expr, unit), nil
+}
+
+// Measure(): returns a Measure node, a number literal followed by a unit (5 km), or the number literal
+func (p *Parser) Measure(token *token.Token, number ast.ASTNode) (ast.ASTNode, error) {
+
+    // measure: (INTEGER|FLOAT) units?, where the unit is not a function being called (2 min(1, 2)) and not an
+    // unknown of a system (solve {2m + n = 5; ...})
+    if p.system || !p.isUnit(p.CurrentToken) {
+        return number, nil
+    }
+    if next, err := p.peek(1); err == nil && next.TokenType == LPAR {
+        return number, nil
+    }
+    attached := ""
+    if p.ImplicitMul && p.CurrentToken.Position == token.Position + len(token.Literal) {
+        attached = p.CurrentToken.Literal
+    }
+    unit, err := p.Units()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    return ast.NewMeasure(token, number, unit, attached), nil
+}
+
+// Units(): returns the unit written after a number or after to: km, m/s^2, kg m^2/s^2. A '/' is only part of
+// the unit when it is written without spaces, so 5 km/h is a speed and 5 km / h divides by the variable h.
+func (p *Parser) Units() (units.Unit, error) {
+
+    // units: unitPower (unitPower | DIV unitPower)*
+    unit := units.Unit{}
+    for terms := 0; ; terms++ {
+        exponent := 1
+        switch {
+        case terms == 0 || p.isUnit(p.CurrentToken):
+        case p.CurrentToken.TokenType == DIV && p.unitDivision():
+            if err := p.Consume(DIV); err != nil {
+                return nil, err
+            }
+            exponent = -1
+        default:
+            return unit, nil
+        }
+        power, err := p.UnitPower()
+        if err != nil {
+            return nil, err
+        }
+        unit = unit.Times(units.Unit{power}, exponent)
+    }
+}
+
+// UnitPower(): returns a unit raised to a power, m^2 or s^-1
+func (p *Parser) UnitPower() (units.Power, error) {
+
+    // unitPower: IDENT (CARET MINUS? INTEGER)?
+    token := p.CurrentToken
+    if token.TokenType != IDENT {
+        return units.Power{}, fmt.Errorf("parser.UnitPower(): expected a unit but received %s at column %d",
+            token.TokenType, token.Position + 1)
+    }
+    if !p.isUnit(token) {
+        return units.Power{}, fmt.Errorf("parser.UnitPower(): unknown unit %s at column %d", token.Literal,
+            token.Position + 1)
+    }
+    if err := p.Consume(IDENT); err != nil {
+        return units.Power{}, err
+    }
+    power := units.Power{Name: token.Literal, Exponent: 1}
+    if p.CurrentToken.TokenType != CARET || p.Programmer {
+        return power, nil
+    }
+    // only an integer exponent belongs to the unit, (2 m)^x is written 2 m^x
+    if next, err := p.peek(1); err != nil || next.TokenType != INTEGER && next.TokenType != MINUS {
+        return power, nil
+    }
+    if err := p.Consume(CARET); err != nil {
+        return units.Power{}, err
+    }
+    sign := 1
+    if p.CurrentToken.TokenType == MINUS {
+        if err := p.Consume(MINUS); err != nil {
+            return units.Power{}, err
+        }
+        sign = -1
+    }
+    exponent := p.CurrentToken
+    if err := p.Consume(INTEGER); err != nil {
+        return units.Power{}, err
+    }
+    power.Exponent = sign * exponent.Value.(int)
+    return power, nil
+}
+
+// isUnit reports whether a token is the name of a unit
+func (p *Parser) isUnit(token *token.Token) bool {
+    name, ok := token.Value.(string)
+    return token.TokenType == IDENT && ok && units.Known(name)
+}
+
+// unitDivision reports whether the current '/' divides two units, written without spaces: km/h
+func (p *Parser) unitDivision() bool {
+    slash := p.CurrentToken
+    next, err := p.peek(1)
+    return err == nil && p.isUnit(next) && next.Position == slash.Position + 1 &&
+        p.previous.Position + len(p.previous.Literal) == slash.Position
+}
+
+// peek returns the n-th token after the current one, without consuming anything
+func (p *Parser) peek(n int) (*token.Token, error) {
+    lex := *p.Lex
+    var next *token.Token
+    var err error
+    for i := 0; i < n && err == nil; i++ {
+        next, err = lex.GetNextToken()
+    }
+    return next, err
+}
+
+// Range(): returns a Range node, start..end, or an Or() subtree. A range binds less tightly than any operator
+// other than ?:, so 1..n + 1 is 1..(n + 1). 
+func (p *Parser) Range() (ast.ASTNode, error) {
+
+    // range: or(DOTDOT or)?
+    start, err := p.Or()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    if p.CurrentToken.TokenType != DOTDOT {
+        return start, nil
+    }
+    token := p.CurrentToken
+    if err := p.Consume(DOTDOT); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    end, err := p.Or()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    return ast.NewRange(token, start, end), nil
+}
+
+// Body(): returns the Block of a loop body, which must be in braces (an empty body is allowed)
+func (p *Parser) Body() (*ast.Block, error) {
+
+    // body: LBRACE statementList RBRACE
+    token := p.CurrentToken
+    if err := p.Consume(LBRACE); err != nil {
+        return nil, err
+    }
+    p.loops++
+    block, err := p.StatementList(token, RBRACE)
+    p.loops--
+    if err != nil {
+        return nil, err
+    }
+    if err := p.Consume(RBRACE); err != nil {
+        return nil, err
+    }
+    return block.(*ast.Block), nil
+}
+
+// Statement(): returns an ASTNode: a While, For, Break or Assignment, or a Ternary() subtree
+func (p *Parser) Statement() (ast.ASTNode, error) {
+
+    // statement: WHILE ternary body|FOR IDENT IN range body|BREAK|IDENT ASSIGN ternary|
+    //            call ASSIGN ternary|ternary
+    token := p.CurrentToken
+    switch token.TokenType {
+    case WHILE:
+        if err := p.Consume(WHILE); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        condition, err := p.Ternary()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        body, err := p.Body()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return ast.NewWhile(token, condition, body), nil
+
+    case FOR:
+        if err := p.Consume(FOR); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        variableToken := p.CurrentToken
+        if err := p.Consume(IDENT); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        variable, err := ast.NewVariable(variableToken)
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        if err := p.Consume(IN); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        bounds, err := p.Ternary()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        rangeNode, ok := bounds.(*ast.Range)
+        if !ok {
+            err := fmt.Errorf("parser.Statement(): expected a range start..end after in, not %v", bounds)
+            return ast.NewErrorNode(err), err
+        }
+        body, err := p.Body()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return ast.NewFor(token, variable.(*ast.Variable), rangeNode.Start, rangeNode.End, body), nil
+
+    case BREAK:
+        if p.loops == 0 {
+            err := fmt.Errorf("parser.Statement(): break outside of a loop")
+            return ast.NewErrorNode(err), err
+        }
+        if err := p.Consume(BREAK); err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        return ast.NewBreak(token), nil
+    }
+
+    // anything else is an expression, which is the target of an assignment if it is followed by ASSIGN 
+    expression, err := p.Ternary()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    if p.CurrentToken.TokenType != ASSIGN {
+        return expression, nil
+    }
+    if call, ok := expression.(*ast.Call); ok {
+        return p.FunctionDefinition(call)
+    }
+    variable, ok := expression.(*ast.Variable)
+    if !ok {
+        err := fmt.Errorf("parser.Statement(): cannot assign to %v", expression)
+        return ast.NewErrorNode(err), err
+    }
+    assignToken := p.CurrentToken
+    if err := p.Consume(ASSIGN); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    value, err := p.Ternary()
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    return ast.NewAssignment(assignToken, variable, value), nil
+}
+
+// StatementList(): returns a Block of the statements up to (not including) a token of type end. Statements
+// are separated by one or more SEMI tokens (';' or a newline), and separators at the start or end are ignored
+func (p *Parser) StatementList(start *token.Token, end string) (ast.ASTNode, error) {
+
+    // statementList: SEMI* (statement (SEMI+ statement)* SEMI*)?
+    statements := make([]ast.ASTNode, 0)
+    for p.CurrentToken.TokenType != end {
+        if p.CurrentToken.TokenType == SEMI {
+            if err := p.Consume(SEMI); err != nil {
+                return ast.NewErrorNode(err), err
+            }
+            continue
+        }
+        statement, err := p.Statement()
+        if err != nil {
+            return ast.NewErrorNode(err), err
+        }
+        statements = append(statements, statement)
+        if p.CurrentToken.TokenType != SEMI && p.CurrentToken.TokenType != end {
+            err := fmt.Errorf("parser.StatementList(): unexpected %s at end of statement", p.CurrentToken.TokenType)
+            return ast.NewErrorNode(err), err
+        }
+    }
+    return ast.NewBlock(start, statements), nil
+}
+
+// FunctionDefinition(): returns a FunctionDefinition node for a call followed by ASSIGN, whose arguments must
+// all be distinct names: f(x, y) = x * y
+func (p *Parser) FunctionDefinition(call *ast.Call) (ast.ASTNode, error) {
+    parameters := make([]*ast.Variable, len(call.Arguments))
+    names := make(map[string]bool)
+    for i, argument := range call.Arguments {
+        parameter, ok := argument.(*ast.Variable)
+        if !ok || names[parameter.Name] {
+            err := fmt.Errorf("parser.FunctionDefinition(): invalid parameter %v of %s", argument, call.Name)
+            return ast.NewErrorNode(err), err
+        }
+        names[parameter.Name] = true
+        parameters[i] = parameter
+    }
+    if err := p.Consume(ASSIGN); err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    // break inside the body cannot leave a loop around the definition 
+    loops := p.loops
+    p.loops = 0
+    body, err := p.Ternary()
+    p.loops = loops
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
+    return ast.NewFunctionDefinition(call.Token, call.Name, parameters, body), nil
+}
+
+// final return point to Interpreter: returns root of AST to interpreter, a Block holding every statement
+// in the input
+func (p *Parser) Parse() (ast.ASTNode, error) {
+    rootNode, err := p.StatementList(p.CurrentToken, EOF)
+    if err != nil {
+        return ast.NewErrorNode(err), err
+    }
 
     // make sure stack is empty 
-    if !p.Stack.IsEmpty() {
-        err := fmt.Errorf("parser.Parse(): missing opening or closing parentheses: parentheses not balanced")
-        return ast.NewErrorNode(err),err
-    }    
-    // make sure all input was parsed 
-    if p.CurrentToken.TokenType != EOF {
-        err := fmt.Errorf("parser.Parse(): unexpected input at end of expression")
-        return ast.NewErrorNode(err),err
+    if err := p.Stack.Match(*p.CurrentToken); err != nil {
+        return ast.NewErrorNode(err), err
     }
     // everything went well: return the AST of the input to the interpreter 
-    return rootNode, err
+    return rootNode, nil
 }
@@ -0,0 +1,273 @@
+package main
+
+/*
+
+Saving a REPL session to a file and loading it back. A session file is plain text in sections, each started
+by a [section] line:
+
+    [settings]
+    base = 10
+    ...
+    [history]
+    $1 = 42
+    [variables]
+    x = 2.5
+    [functions]
+    f(x) = x * 2
+
+Settings are written as :set takes them, values as they are printed in the default format (floats always
+with a '.' or an exponent so they read back as floats), and function definitions as calculator input.
+Empty lines and lines starting with '#' are ignored. Loading a file replaces the variables, functions and
+results of the session and applies its settings, in the order of the file, so it always gives the same
+session back.
+
+*/
+
+import (
+    "calculator/ast"
+    "calculator/interpreter"
+    "calculator/lexer"
+    "calculator/parser"
+    "fmt"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// readSections reads a file made of [section] lines each followed by the lines of that section, calling
+// handle with every line that is not empty or a comment. Errors name the file and the line they are on.
+func readSections(path string, sections []string, handle func(section, line string) error) error {
+    input, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    section := ""
+    for number, line := range strings.Split(string(input), "\n") {
+        line = strings.TrimSpace(line)
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+            section = line[1:len(line) - 1]
+            known := false
+            for _, name := range sections {
+                known = known || name == section
+            }
+            if !known {
+                return fmt.Errorf("%s:%d: unknown section [%s], expected one of [%s]", path, number + 1, section,
+                    strings.Join(sections, "], ["))
+            }
+            continue
+        }
+        if section == "" {
+            return fmt.Errorf("%s:%d: line before the first [section]", path, number + 1)
+        }
+        if err := handle(section, line); err != nil {
+            return fmt.Errorf("%s:%d: %v", path, number + 1, err)
+        }
+    }
+    return nil
+}
+
+// splitPair splits a "name = value" line
+func splitPair(line string) (string, string, error) {
+    name, value, ok := strings.Cut(line, "=")
+    if !ok {
+        return "", "", fmt.Errorf("expected name = value, not %s", line)
+    }
+    return strings.TrimSpace(name), strings.TrimSpace(value), nil
+}
+
+// writeValue writes a result or variable value so that readValue gives the same value back
+func writeValue(value interface{}) (string, error) {
+    switch v := value.(type) {
+    case int:
+        return strconv.Itoa(v), nil
+    case bool:
+        return strconv.FormatBool(v), nil
+    case float64:
+        text := strconv.FormatFloat(v, 'g', -1, 64)
+        if !strings.ContainsAny(text, ".eIN") {
+            text += ".0" // 220.0, not the int 220
+        }
+        return text, nil
+    case complex128:
+        return strconv.FormatComplex(v, 'g', -1, 128), nil // (1+2i)
+    case *interpreter.Matrix:
+        text := v.Format(func(element interface{}) string {
+            text, _ := writeValue(element) // the elements are numbers
+            return text
+        })
+        if v.Rows == 1 {
+            text = strings.TrimSuffix(text, "]") + ";]" // a matrix of one row, not a list
+        }
+        return text, nil
+    case *interpreter.List:
+        return v.Format(func(element interface{}) string {
+            text, _ := writeValue(element)
+            return text
+        }), nil
+    case *interpreter.Quantity:
+        return v.Format(func(number float64) string {
+            text, _ := writeValue(number)
+            return text
+        }), nil
+    default:
+        return "", fmt.Errorf("cannot save a value of type %T", value)
+    }
+}
+
+// readValue reads a value written by writeValue
+func readValue(text string) (interface{}, error) {
+    if text == "true" || text == "false" {
+        return text == "true", nil
+    }
+    if integer, err := strconv.Atoi(text); err == nil {
+        return integer, nil
+    }
+    if number, err := strconv.ParseFloat(text, 64); err == nil {
+        return number, nil
+    }
+    if strings.HasPrefix(text, "(") {
+        if number, err := strconv.ParseComplex(text, 128); err == nil {
+            return number, nil
+        }
+    }
+    if strings.HasPrefix(text, "[") || strings.Contains(text, " ") {
+        // a matrix or list literal, of numbers only, or a number with a unit
+        p, err := parser.NewParser(lexer.NewLexer(text))
+        if err == nil {
+            var value interface{}
+            if value, err = interpreter.NewInterpreter(p).Evaluate(); err == nil {
+                switch value.(type) {
+                case *interpreter.Matrix, *interpreter.List, *interpreter.Quantity:
+                    return value, nil
+                }
+            }
+        }
+    }
+    return nil, fmt.Errorf("invalid value: %s", text)
+}
+
+var sessionSections = []string{"settings", "history", "variables", "functions"}
+
+// save writes the session to a file, see the top of this file for the format
+func (s *session) save(path string) error {
+    var file strings.Builder
+    file.WriteString("# calculator session, load it with :load or the -load flag\n[settings]\n")
+    for _, setting := range s.settings() {
+        name, value, _ := strings.Cut(setting, "=")
+        fmt.Fprintf(&file, "%s = %s\n", name, value)
+    }
+    file.WriteString("[history]\n")
+    for i, result := range s.history {
+        text, err := writeValue(result)
+        if err != nil {
+            return fmt.Errorf("save: $%d: %v", i + 1, err)
+        }
+        fmt.Fprintf(&file, "$%d = %s\n", i + 1, text)
+    }
+    file.WriteString("[variables]\n")
+    names := make([]string, 0, len(s.variables))
+    for name := range s.variables {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        text, err := writeValue(s.variables[name])
+        if err != nil {
+            return fmt.Errorf("save: %s: %v", name, err)
+        }
+        fmt.Fprintf(&file, "%s = %s\n", name, text)
+    }
+    file.WriteString("[functions]\n")
+    names = names[:0]
+    for name := range s.functions {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        file.WriteString(interpreter.Source(s.functions[name]) + "\n")
+    }
+    return os.WriteFile(path, []byte(file.String()), 0644)
+}
+
+// load replaces the session with the one saved in a file. Nothing changes if the file has an error.
+func (s *session) load(path string) error {
+    loaded := *s
+    loaded.clear()
+    err := readSections(path, sessionSections, func(section, line string) error {
+        if section == "functions" {
+            return loaded.define(line, false)
+        }
+        name, text, err := splitPair(line)
+        if err != nil {
+            return err
+        }
+        switch section {
+        case "settings":
+            return loaded.set(name, text)
+        case "history":
+            if name != "$" + strconv.Itoa(len(loaded.history) + 1) {
+                return fmt.Errorf("expected $%d, not %s", len(loaded.history) + 1, name)
+            }
+        }
+        value, err := readValue(text)
+        if err != nil {
+            return err
+        }
+        if section == "history" {
+            loaded.history = append(loaded.history, value)
+            return nil
+        }
+        if _, ok := interpreter.LookupConstant(name); ok || !validName(name) {
+            return fmt.Errorf("invalid variable name: %s", name)
+        }
+        loaded.variables[name] = value
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    *s = loaded
+    return nil
+}
+
+// validName reports whether a name can be assigned to, that is the lexer reads it as a single name
+func validName(name string) bool {
+    first, err := lexer.NewLexer(name).GetNextToken()
+    return err == nil && first.TokenType == lexer.IDENT && first.Literal == name
+}
+
+// define evaluates a line of function definitions, and of assignments if they are allowed. Anything else on
+// the line is an error. 
+func (s *session) define(line string, assignments bool) error {
+    check, err := parser.NewParser(lexer.NewLexer(line))
+    if err != nil {
+        return err
+    }
+    check.ImplicitMul = s.implicit
+    check.Programmer = s.word != nil
+    root, err := check.Parse()
+    if err != nil {
+        return err
+    }
+    for _, statement := range root.(*ast.Block).Statements {
+        _, definition := statement.(*ast.FunctionDefinition)
+        _, assignment := statement.(*ast.Assignment)
+        if !definition && !(assignments && assignment) {
+            expected := "a function definition"
+            if assignments {
+                expected = "an assignment or a function definition"
+            }
+            return fmt.Errorf("expected %s, not %s", expected, interpreter.Source(statement))
+        }
+    }
+    interp, err := s.interpreter(line)
+    if err != nil {
+        return err
+    }
+    _, err = interp.Evaluate()
+    return err
+}
@@ -4,10 +4,13 @@ import (
     "fmt"
 )
 
-// token struct, has type and value
+// token struct, has type, value, the byte offset in the input where the token starts and the original
+// spelling of the token (e.g. 0xFF or 1_000 for an integer)
 type Token struct {
     TokenType string
     Value     interface{}
+    Position  int
+    Literal   string
 }
 
 // creates a new token, returns ptr to the new token
@@ -0,0 +1,235 @@
+package units
+
+/*
+
+Units of measure for quantities such as 5 km or 9.8 m/s^2. Each named unit is a multiple (its factor) of a
+product of powers of the SI base units (its dimension): km is 1000 m, N is 1 kg m/s^2. A Unit is a product
+of powers of named units kept as they were written, so 5 km / 2 h is 2.5 km/h rather than 0.69 m/s, and two
+units can be converted into each other when they have the same dimension.
+
+*/
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+)
+
+// Dimension holds the exponents of the SI base units m, kg, s, A, K, mol and cd
+type Dimension [7]int
+
+var baseNames = [7]string{"m", "kg", "s", "A", "K", "mol", "cd"}
+
+func (d Dimension) String() string {
+    var unit Unit
+    for i, exponent := range d {
+        if exponent != 0 {
+            unit = append(unit, Power{Name: baseNames[i], Exponent: exponent})
+        }
+    }
+    if len(unit) == 0 {
+        return "1"
+    }
+    return unit.String()
+}
+
+// definition of a named unit: how many of its dimension's SI units it is
+type definition struct {
+    factor    float64
+    dimension Dimension
+}
+
+var (
+    length      = Dimension{1, 0, 0, 0, 0, 0, 0}
+    mass        = Dimension{0, 1, 0, 0, 0, 0, 0}
+    time        = Dimension{0, 0, 1, 0, 0, 0, 0}
+    current     = Dimension{0, 0, 0, 1, 0, 0, 0}
+    temperature = Dimension{0, 0, 0, 0, 1, 0, 0}
+    amount      = Dimension{0, 0, 0, 0, 0, 1, 0}
+    luminosity  = Dimension{0, 0, 0, 0, 0, 0, 1}
+    area        = Dimension{2, 0, 0, 0, 0, 0, 0}
+    volume      = Dimension{3, 0, 0, 0, 0, 0, 0}
+    speed       = Dimension{1, 0, -1, 0, 0, 0, 0}
+    frequency   = Dimension{0, 0, -1, 0, 0, 0, 0}
+    force       = Dimension{1, 1, -2, 0, 0, 0, 0}
+    energy      = Dimension{2, 1, -2, 0, 0, 0, 0}
+    power       = Dimension{2, 1, -3, 0, 0, 0, 0}
+    pressure    = Dimension{-1, 1, -2, 0, 0, 0, 0}
+    charge      = Dimension{0, 0, 1, 1, 0, 0, 0}
+    voltage     = Dimension{2, 1, -3, -1, 0, 0, 0}
+    resistance  = Dimension{2, 1, -3, -2, 0, 0, 0}
+)
+
+// the named units. Temperatures other than K are left out, their scales do not start at 0.
+var definitions = map[string]definition{
+    "m":     {1, length},
+    "km":    {1e3, length},
+    "cm":    {1e-2, length},
+    "mm":    {1e-3, length},
+    "um":    {1e-6, length},
+    "nm":    {1e-9, length},
+    "inch":  {0.0254, length}, // "in" is a keyword
+    "ft":    {0.3048, length},
+    "yd":    {0.9144, length},
+    "mi":    {1609.344, length},
+    "nmi":   {1852, length},
+    "kg":    {1, mass},
+    "g":     {1e-3, mass},
+    "mg":    {1e-6, mass},
+    "tonne": {1e3, mass},
+    "lb":    {0.45359237, mass},
+    "oz":    {0.45359237 / 16, mass},
+    "s":     {1, time},
+    "ms":    {1e-3, time},
+    "us":    {1e-6, time},
+    "ns":    {1e-9, time},
+    "min":   {60, time},
+    "h":     {3600, time},
+    "day":   {86400, time},
+    "week":  {604800, time},
+    "yr":    {365.25 * 86400, time}, // a Julian year
+    "A":     {1, current},
+    "mA":    {1e-3, current},
+    "K":     {1, temperature},
+    "mol":   {1, amount},
+    "cd":    {1, luminosity},
+    "ha":    {1e4, area},
+    "L":     {1e-3, volume},
+    "mL":    {1e-6, volume},
+    "mph":   {1609.344 / 3600, speed},
+    "kn":    {1852.0 / 3600, speed},
+    "Hz":    {1, frequency},
+    "kHz":   {1e3, frequency},
+    "MHz":   {1e6, frequency},
+    "GHz":   {1e9, frequency},
+    "N":     {1, force},
+    "kN":    {1e3, force},
+    "J":     {1, energy},
+    "kJ":    {1e3, energy},
+    "cal":   {4.184, energy},
+    "kcal":  {4184, energy},
+    "Wh":    {3600, energy},
+    "kWh":   {3.6e6, energy},
+    "eV":    {1.602176634e-19, energy},
+    "W":     {1, power},
+    "kW":    {1e3, power},
+    "MW":    {1e6, power},
+    "hp":    {745.69987158227022, power}, // mechanical horsepower
+    "Pa":    {1, pressure},
+    "kPa":   {1e3, pressure},
+    "bar":   {1e5, pressure},
+    "atm":   {101325, pressure},
+    "psi":   {6894.757293168361, pressure},
+    "C":     {1, charge},
+    "V":     {1, voltage},
+    "ohm":   {1, resistance},
+}
+
+// Known reports whether name is the name of a unit
+func Known(name string) bool {
+    _, ok := definitions[name]
+    return ok
+}
+
+// Names returns the names of all units, sorted
+func Names() []string {
+    names := make([]string, 0, len(definitions))
+    for name := range definitions {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Power is a named unit raised to a power, m^2
+type Power struct {
+    Name     string
+    Exponent int
+}
+
+// Unit is a product of powers of named units, each name at most once. The empty Unit is a pure number.
+type Unit []Power
+
+// Times returns the unit u v^exponent, with the powers of the same name added up and those that cancel out
+// left out: km/h times h is km
+func (u Unit) Times(v Unit, exponent int) Unit {
+    result := make(Unit, len(u))
+    copy(result, u)
+    for _, power := range v {
+        found := false
+        for i := range result {
+            if result[i].Name == power.Name {
+                result[i].Exponent += power.Exponent * exponent
+                found = true
+            }
+        }
+        if !found {
+            result = append(result, Power{Name: power.Name, Exponent: power.Exponent * exponent})
+        }
+    }
+    kept := result[:0]
+    for _, power := range result {
+        if power.Exponent != 0 {
+            kept = append(kept, power)
+        }
+    }
+    return kept
+}
+
+// Pow returns the unit raised to a power
+func (u Unit) Pow(exponent int) Unit {
+    return Unit{}.Times(u, exponent)
+}
+
+// Dimension returns the dimension of the unit
+func (u Unit) Dimension() Dimension {
+    var d Dimension
+    for _, power := range u {
+        for i, exponent := range definitions[power.Name].dimension {
+            d[i] += exponent * power.Exponent
+        }
+    }
+    return d
+}
+
+// Factor returns how many of the SI units of its dimension the unit is: 1000 for km, 1/3.6 for km/h
+func (u Unit) Factor() float64 {
+    factor := 1.0
+    for _, power := range u {
+        factor *= math.Pow(definitions[power.Name].factor, float64(power.Exponent))
+    }
+    return factor
+}
+
+// String writes the unit the way it is written after a number: kg m/s^2. The powers with negative exponents
+// each follow a '/', unless there are only such powers (s^-1).
+func (u Unit) String() string {
+    var numerator, denominator []string
+    for _, power := range u {
+        if power.Exponent > 0 {
+            numerator = append(numerator, power.written(power.Exponent))
+        } else {
+            denominator = append(denominator, power.written(-power.Exponent))
+        }
+    }
+    if len(numerator) == 0 {
+        for _, power := range u {
+            numerator = append(numerator, power.written(power.Exponent))
+        }
+        return strings.Join(numerator, " ")
+    }
+    text := strings.Join(numerator, " ")
+    for _, power := range denominator {
+        text += "/" + power
+    }
+    return text
+}
+
+// written writes the power's name with an exponent
+func (p Power) written(exponent int) string {
+    if exponent == 1 {
+        return p.Name
+    }
+    return fmt.Sprintf("%s^%d", p.Name, exponent)
+}